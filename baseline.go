@@ -0,0 +1,97 @@
+// Adopts databases whose schema predates their migrations.
+
+package gomigrate
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Baseline records every migration up to and including id as applied,
+// without running them, for databases whose schema already matches them.
+// The row for id records description as its name, and is added even if no
+// migration with that id is loaded.  It creates the migration table if
+// needed, and fails with ErrAlreadyMigrated if any migration is already
+// recorded.  If the adapter implements Locker the migration lock is held.
+func (m *Migrator) Baseline(id uint64, description string) error {
+	return m.BaselineContext(context.Background(), id, description)
+}
+
+// BaselineContext is like Baseline but honours the cancellation and
+// deadline of the given context.
+func (m *Migrator) BaselineContext(ctx context.Context, id uint64, description string) error {
+	if id == 0 {
+		return &ErrInvalidMigration{
+			ID:   id,
+			Name: description,
+			Err:  "Id can't be zero",
+		}
+	}
+	return m.withLock(ctx, func() error {
+		return m.baseline(ctx, id, description)
+	})
+}
+
+func (m *Migrator) baseline(ctx context.Context, id uint64, description string) error {
+	if err := m.ensureMigrationsTable(ctx); err != nil {
+		return err
+	}
+	entries, err := m.migrationLog(ctx)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("applied: %d, err: %w", len(entries), ErrAlreadyMigrated)
+	}
+
+	var baselined []*Migration
+	for _, migration := range m.Migrations(-1) {
+		if migration.ID <= id {
+			baselined = append(baselined, migration)
+		}
+	}
+	if len(baselined) == 0 || baselined[len(baselined)-1].ID != id {
+		baselined = append(baselined, &Migration{ID: id, Name: description})
+	}
+
+	m.log().Info("Baselining migrations", "migration_id", id, "description", description, "count", len(baselined))
+	transaction, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		m.log().Error("Error opening transaction", "error", err)
+		return err
+	}
+	appliedAt := time.Now().UTC()
+	for _, migration := range baselined {
+		name := migration.Name
+		if migration.ID == id && description != "" {
+			name = description
+		}
+		checksum, err := m.checksum(migration)
+		if err == nil {
+			_, err = transaction.ExecContext(
+				ctx,
+				m.dbAdapter.MigrationLogInsertSql(m.table()),
+				migration.ID,
+				name,
+				appliedAt,
+				0,
+				checksum,
+				m.AppliedBy,
+			)
+		}
+		if err != nil {
+			m.log().Error("Error baselining migration", "migration_id", migration.ID, "name", migration.Name, "error", err)
+			return m.rollback(m.log(), transaction, newMigrationError(migration, UpMigration, nil, -1, err))
+		}
+	}
+	if err := transaction.Commit(); err != nil {
+		m.log().Error("Error commiting transaction", "error", err)
+		return err
+	}
+
+	for _, migration := range baselined {
+		migration.Status = Active
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+// Command gomigrate applies the migrations in a directory to a database.
+//
+// Usage:
+//
+//	gomigrate -driver NAME -dsn DSN [flags] COMMAND [ARGS]
+//
+// Commands:
+//
+//	up              apply every pending migration
+//	down [N]        roll back the last N migrations, 1 by default
+//	to VERSION      migrate up or down to the given migration id
+//	status          list every migration and its state
+//	create NAME     create an empty pair of migration files, numbered after
+//	                the last one or, with -timestamp, with the current time
+//	redo            roll back the last migration and apply it again
+//	verify          report applied migrations that changed since
+//	baseline VERSION [DESCRIPTION]
+//	                record migrations up to VERSION as applied without
+//	                running them
+//
+// The driver and DSN may also be given with the GOMIGRATE_DRIVER and
+// GOMIGRATE_DSN environment variables.  gomigrate exits with status 1 when a
+// command fails and 2 when it's used incorrectly.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/denisenkom/go-mssqldb"
+	"github.com/derkan/gomigrate"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var errUsage = errors.New("usage")
+
+// Number of arguments taken by each command.
+var commandArgs = map[string][2]int{
+	"up":       {0, 0},
+	"down":     {0, 1},
+	"to":       {1, 1},
+	"status":   {0, 0},
+	"create":   {1, 1},
+	"redo":     {0, 0},
+	"verify":   {0, 0},
+	"baseline": {1, 2},
+}
+
+var (
+	driver       = flag.String("driver", "", "database/sql driver: postgres, mysql, sqlite3 or mssql, $GOMIGRATE_DRIVER by default")
+	dsn          = flag.String("dsn", "", "data source name passed to the driver, $GOMIGRATE_DSN by default")
+	dialect      = flag.String("dialect", "", "database behind the driver when it isn't the default one: cockroachdb or mariadb")
+	dir          = flag.String("dir", "migrations", "directory holding the migration files")
+	table        = flag.String("table", "", "name of the migration table, gomigrate by default")
+	schema       = flag.String("schema", "", "schema of the migration table, the current one by default")
+	timeout      = flag.Duration("timeout", 0, "give up if the command takes longer than this")
+	lockTimeout  = flag.Duration("lock-timeout", gomigrate.DefaultLockTimeout, "how long to wait for another process migrating the database")
+	quiet        = flag.Bool("q", false, "don't log what's being done")
+	timestampIDs = flag.Bool("timestamp", false, "number migrations made by create with the current UTC time")
+	outOfOrder   = flag.Bool("allow-out-of-order", false, "apply pending migrations older than the newest applied one")
+	singleTx     = flag.Bool("single-transaction", false, "make up apply every pending migration in one transaction")
+	strict       = flag.Bool("strict-templates", false, "fail on template variables that aren't set")
+	vars         = templateVars{}
+)
+
+func init() {
+	flag.Var(vars, "var", "set a template variable, as NAME=VALUE, may be repeated")
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	err := run(flag.Args())
+	if errors.Is(err, errUsage) {
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gomigrate: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprint(flag.CommandLine.Output(), `Usage: gomigrate -driver NAME -dsn DSN [flags] COMMAND [ARGS]
+
+Commands:
+  up              apply every pending migration
+  down [N]        roll back the last N migrations, 1 by default
+  to VERSION      migrate up or down to the given migration id
+  status          list every migration and its state
+  create NAME     create an empty pair of migration files, numbered after
+                  the last one or, with -timestamp, with the current time
+  redo            roll back the last migration and apply it again
+  verify          report applied migrations that changed since
+  baseline VERSION [DESCRIPTION]
+                  record migrations up to VERSION as applied without
+                  running them
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
+
+func run(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	command, args := args[0], args[1:]
+	if n, ok := commandArgs[command]; !ok || len(args) < n[0] || len(args) > n[1] {
+		return errUsage
+	}
+	if *driver == "" {
+		*driver = os.Getenv("GOMIGRATE_DRIVER")
+	}
+	if *dsn == "" {
+		*dsn = os.Getenv("GOMIGRATE_DSN")
+	}
+
+	var logger gomigrate.Logger = log.New(os.Stderr, "[gomigrate] ", log.LstdFlags)
+	if *quiet {
+		logger = log.New(ioutil.Discard, "", 0)
+	}
+
+	// Creating migrations doesn't need a database.
+	if command == "create" {
+		scheme := gomigrate.SequentialIDs
+		if *timestampIDs {
+			scheme = gomigrate.TimestampIDs
+		}
+		up, down, err := gomigrate.CreateMigration(*dir, args[0], scheme)
+		if err != nil {
+			return err
+		}
+		fmt.Println(up)
+		fmt.Println(down)
+		return nil
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	adapter, err := adapterFor(*driver, *dialect)
+	if err != nil {
+		return err
+	}
+	if *dsn == "" {
+		return fmt.Errorf("no data source name given")
+	}
+	db, err := sql.Open(*driver, *dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m, err := gomigrate.NewMigratorWithLogger(db, adapter, *dir, logger)
+	if err != nil {
+		return err
+	}
+	m.TableName = *table
+	m.Schema = *schema
+	m.LockTimeout = *lockTimeout
+	m.AllowOutOfOrder = *outOfOrder
+	m.SingleTransaction = *singleTx
+	if len(vars) > 0 || *strict {
+		m.TemplateData = vars
+		m.StrictTemplates = *strict
+	}
+
+	switch command {
+	case "up":
+		return m.MigrateContext(ctx)
+	case "down":
+		n := 1
+		if len(args) == 1 {
+			if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+				return fmt.Errorf("invalid number of migrations: %s", args[0])
+			}
+		}
+		return m.RollbackNContext(ctx, n)
+	case "to":
+		id, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid version: %s", args[0])
+		}
+		return m.MigrateToContext(ctx, id)
+	case "status":
+		return status(ctx, m)
+	case "redo":
+		return redo(ctx, m)
+	case "verify":
+		return verify(ctx, m)
+	case "baseline":
+		id, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid version: %s", args[0])
+		}
+		description := "baseline"
+		if len(args) == 2 {
+			description = args[1]
+		}
+		return m.BaselineContext(ctx, id, description)
+	}
+	return errUsage
+}
+
+// Template variables given with -var.
+type templateVars map[string]string
+
+func (v templateVars) String() string {
+	return ""
+}
+
+func (v templateVars) Set(value string) error {
+	name, varValue, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected NAME=VALUE")
+	}
+	v[name] = varValue
+	return nil
+}
+
+// Returns the adapter for a driver, or for the dialect if one is given.
+func adapterFor(driver, dialect string) (gomigrate.Migratable, error) {
+	switch dialect {
+	case "":
+	case "cockroachdb":
+		return gomigrate.CockroachDB{}, nil
+	case "mariadb":
+		return gomigrate.Mariadb{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported dialect: %s", dialect)
+	}
+
+	switch driver {
+	case "postgres":
+		return gomigrate.Postgres{}, nil
+	case "mysql":
+		return gomigrate.Mysql{}, nil
+	case "sqlite3":
+		return gomigrate.Sqlite3{}, nil
+	case "mssql":
+		return gomigrate.Mssql{}, nil
+	case "sqlserver":
+		// The adapter's queries use ? placeholders, which only the mssql
+		// driver name rewrites.
+		return nil, fmt.Errorf("unsupported driver: sqlserver, use mssql instead")
+	case "":
+		return nil, fmt.Errorf("no driver given")
+	}
+	return nil, fmt.Errorf("unsupported driver: %s", driver)
+}
+
+func status(ctx context.Context, m *gomigrate.Migrator) error {
+	report, err := m.StatusContext(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSTATE\tAPPLIED AT")
+	for _, s := range report {
+		appliedAt := ""
+		if !s.AppliedAt.IsZero() {
+			appliedAt = s.AppliedAt.Local().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", s.ID, s.Name, s.State, appliedAt)
+	}
+	return w.Flush()
+}
+
+func redo(ctx context.Context, m *gomigrate.Migrator) error {
+	if _, err := m.StatusContext(ctx); err != nil {
+		return err
+	}
+	applied := m.Migrations(gomigrate.Active)
+	if len(applied) == 0 {
+		return fmt.Errorf("no migration to redo")
+	}
+	return m.RedoContext(ctx)
+}
+
+func verify(ctx context.Context, m *gomigrate.Migrator) error {
+	mismatches, err := m.VerifyContext(ctx)
+	if err != nil {
+		return err
+	}
+	for _, mismatch := range mismatches {
+		fmt.Printf("%d\t%s\tapplied %s, now %s\n", mismatch.Migration.ID, mismatch.Migration.Name, mismatch.Applied, mismatch.Current)
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%d applied migrations have changed", len(mismatches))
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+// Scaffolds new migration files.
+
+package gomigrate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IDScheme picks the id of a new migration.
+type IDScheme int
+
+const (
+	// SequentialIDs numbers a new migration one after the highest id in
+	// its directory.
+	SequentialIDs IDScheme = iota
+	// TimestampIDs numbers a new migration with the current UTC time, as
+	// in 20060102150405, so migrations written on parallel branches don't
+	// collide.
+	TimestampIDs
+)
+
+const timestampIDLayout = "20060102150405"
+
+var invalidNameChars = regexp.MustCompile(`[^\w-]+`)
+
+// CreateMigration writes an empty pair of up and down migration files for a
+// new migration to dir and returns their paths.  Characters of name that
+// aren't allowed in migration file names are replaced with underscores.
+func CreateMigration(dir, name string, scheme IDScheme) (string, string, error) {
+	name = strings.Trim(invalidNameChars.ReplaceAllString(name, "_"), "_")
+	if name == "" {
+		return "", "", &ErrInvalidMigration{Err: "Name can't be empty"}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", "", err
+	}
+	var lastID uint64
+	for _, entry := range entries {
+		id, _, _, err := parseMigrationPath(entry.Name())
+		if err == nil && id > lastID {
+			lastID = id
+		}
+	}
+
+	id := lastID + 1
+	if scheme == TimestampIDs {
+		timestamp, err := strconv.ParseUint(time.Now().UTC().Format(timestampIDLayout), 10, 64)
+		if err != nil {
+			return "", "", err
+		}
+		// Keep ids increasing when migrations are created within the
+		// same second.
+		if timestamp > lastID {
+			id = timestamp
+		}
+	}
+
+	paths := make([]string, 0, 2)
+	for _, mType := range []MigrationType{UpMigration, DownMigration} {
+		path := filepath.Join(dir, fmt.Sprintf("%d_%s_%s.sql", id, name, mType))
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return "", "", err
+		}
+		_, err = fmt.Fprintf(file, "-- Migration %d %s: %s\n", id, name, mType)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return "", "", err
+		}
+		paths = append(paths, path)
+	}
+	return paths[0], paths[1], nil
+}
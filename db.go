@@ -1,16 +1,50 @@
 package gomigrate
 
-import (
-	"strconv"
-	"strings"
-)
-
+// Migratable is implemented by the adapter for each supported database.
+// Statements about the migration table take the table name as returned by
+// QuoteTable.
 type Migratable interface {
+	// QuoteTable quotes a table name, qualified with its schema unless the
+	// schema is empty.
+	QuoteTable(schema, name string) string
+	// SelectMigrationTableSql finds the table whose name and schema are
+	// given as parameters, an empty schema meaning the current one.
 	SelectMigrationTableSql() string
-	CreateMigrationTableSql() string
-	GetMigrationSql() string
-	MigrationLogInsertSql() string
-	MigrationLogDeleteSql() string
+	CreateMigrationTableSql(table string) string
+	// SelectMigrationTableColumnsSql lists the column names of the table
+	// whose name and schema are given as parameters, an empty schema
+	// meaning the current one.
+	SelectMigrationTableColumnsSql() string
+	// AddMigrationTableColumnSql adds one of the columns introduced after
+	// the original id/migration_id schema to an existing migration table.
+	AddMigrationTableColumnSql(table, column string) string
+	// GetMigrationSql selects the row of the migration whose id is given
+	// as a parameter.
+	//
+	// Deprecated: migration statuses are read with
+	// SelectAppliedMigrationIdsSql, and this is no longer used.
+	GetMigrationSql(table string) string
+	// SelectAppliedMigrationIdsSql lists the id of every applied migration.
+	SelectAppliedMigrationIdsSql(table string) string
+	// SelectMigrationLogSql lists the migration id, name, applied at time
+	// and checksum of every applied migration.
+	SelectMigrationLogSql(table string) string
+	// MigrationLogInsertSql takes the migration id, name, applied at time,
+	// execution time in milliseconds, checksum and applied by as parameters.
+	MigrationLogInsertSql(table string) string
+	MigrationLogDeleteSql(table string) string
+	// CreateRepeatableTableSql creates the table recording the checksum
+	// each repeatable migration was last applied with.
+	CreateRepeatableTableSql(table string) string
+	// SelectRepeatableChecksumsSql lists the name and checksum of every
+	// applied repeatable migration.
+	SelectRepeatableChecksumsSql(table string) string
+	// RepeatableInsertSql takes the name, checksum, applied at time and
+	// applied by of a repeatable migration as parameters.
+	RepeatableInsertSql(table string) string
+	// RepeatableDeleteSql takes the name of a repeatable migration as a
+	// parameter.
+	RepeatableDeleteSql(table string) string
 	GetMigrationCommands(string) []string
 }
 
@@ -18,31 +52,92 @@ type Migratable interface {
 
 type Postgres struct{}
 
+func (p Postgres) QuoteTable(schema, name string) string {
+	return quoteTable(schema, name, `"`, `"`)
+}
+
 func (p Postgres) SelectMigrationTableSql() string {
-	return "SELECT tablename FROM pg_catalog.pg_tables WHERE tablename = $1"
+	return "SELECT tablename FROM pg_catalog.pg_tables WHERE tablename = $1 AND schemaname = COALESCE(NULLIF($2, ''), current_schema())"
 }
 
-func (p Postgres) CreateMigrationTableSql() string {
-	return `CREATE TABLE gomigrate (
+func (p Postgres) CreateMigrationTableSql(table string) string {
+	return `CREATE TABLE ` + table + ` (
                   id           SERIAL       PRIMARY KEY,
-                  migration_id BIGINT       UNIQUE NOT NULL
+                  migration_id BIGINT       UNIQUE NOT NULL,
+                  name         VARCHAR(255) NOT NULL DEFAULT '',
+                  applied_at   TIMESTAMP WITH TIME ZONE NULL,
+                  execution_ms BIGINT       NOT NULL DEFAULT 0,
+                  checksum     VARCHAR(64)  NOT NULL DEFAULT '',
+                  applied_by   VARCHAR(255) NOT NULL DEFAULT ''
+                )`
+}
+
+func (p Postgres) SelectMigrationTableColumnsSql() string {
+	return "SELECT column_name FROM information_schema.columns WHERE table_name = $1 AND table_schema = COALESCE(NULLIF($2, ''), current_schema())"
+}
+
+func (p Postgres) AddMigrationTableColumnSql(table, column string) string {
+	switch column {
+	case "applied_at":
+		return "ALTER TABLE " + table + " ADD COLUMN applied_at TIMESTAMP WITH TIME ZONE NULL"
+	case "execution_ms":
+		return "ALTER TABLE " + table + " ADD COLUMN execution_ms BIGINT NOT NULL DEFAULT 0"
+	case "checksum":
+		return "ALTER TABLE " + table + " ADD COLUMN checksum VARCHAR(64) NOT NULL DEFAULT ''"
+	default:
+		return "ALTER TABLE " + table + " ADD COLUMN " + column + " VARCHAR(255) NOT NULL DEFAULT ''"
+	}
+}
+
+// Deprecated: use SelectAppliedMigrationIdsSql.
+func (p Postgres) GetMigrationSql(table string) string {
+	return `SELECT migration_id FROM ` + table + ` WHERE migration_id = $1`
+}
+
+func (p Postgres) SelectAppliedMigrationIdsSql(table string) string {
+	return "SELECT migration_id FROM " + table
+}
+
+func (p Postgres) SelectMigrationLogSql(table string) string {
+	return "SELECT migration_id, name, applied_at, checksum FROM " + table + " ORDER BY migration_id"
+}
+
+func (p Postgres) MigrationLogInsertSql(table string) string {
+	return "INSERT INTO " + table + " (migration_id, name, applied_at, execution_ms, checksum, applied_by) values ($1, $2, $3, $4, $5, $6)"
+}
+
+func (p Postgres) MigrationLogDeleteSql(table string) string {
+	return "DELETE FROM " + table + " WHERE migration_id = $1"
+}
+
+func (p Postgres) CreateRepeatableTableSql(table string) string {
+	return `CREATE TABLE ` + table + ` (
+                  name       VARCHAR(255) PRIMARY KEY,
+                  checksum   VARCHAR(64)  NOT NULL DEFAULT '',
+                  applied_at TIMESTAMP WITH TIME ZONE NULL,
+                  applied_by VARCHAR(255) NOT NULL DEFAULT ''
                 )`
 }
 
-func (p Postgres) GetMigrationSql() string {
-	return `SELECT migration_id FROM gomigrate WHERE migration_id = $1`
+func (p Postgres) SelectRepeatableChecksumsSql(table string) string {
+	return "SELECT name, checksum FROM " + table
 }
 
-func (p Postgres) MigrationLogInsertSql() string {
-	return "INSERT INTO gomigrate (migration_id) values ($1)"
+func (p Postgres) RepeatableInsertSql(table string) string {
+	return "INSERT INTO " + table + " (name, checksum, applied_at, applied_by) values ($1, $2, $3, $4)"
 }
 
-func (p Postgres) MigrationLogDeleteSql() string {
-	return "DELETE FROM gomigrate WHERE migration_id = $1"
+func (p Postgres) RepeatableDeleteSql(table string) string {
+	return "DELETE FROM " + table + " WHERE name = $1"
 }
 
 func (p Postgres) GetMigrationCommands(sql string) []string {
-	return []string{sql}
+	return splitStatements(sql, splitOptions{
+		delimiter:      ";",
+		dollarQuotes:   true,
+		nestedComments: true,
+		atomicBlocks:   true,
+	})
 }
 
 // CockroachDB
@@ -51,58 +146,100 @@ type CockroachDB struct {
 	Postgres
 }
 
-
 // MYSQL
 
 type Mysql struct{}
 
+func (m Mysql) QuoteTable(schema, name string) string {
+	return quoteTable(schema, name, "`", "`")
+}
+
 func (m Mysql) SelectMigrationTableSql() string {
-	return "SELECT table_name FROM information_schema.tables WHERE table_name = ? AND table_schema = (SELECT DATABASE())"
+	return "SELECT table_name FROM information_schema.tables WHERE table_name = ? AND table_schema = COALESCE(NULLIF(?, ''), DATABASE())"
 }
 
-func (m Mysql) CreateMigrationTableSql() string {
-	return `CREATE TABLE gomigrate (
+func (m Mysql) CreateMigrationTableSql(table string) string {
+	return `CREATE TABLE ` + table + ` (
                   id           INT          NOT NULL AUTO_INCREMENT,
                   migration_id BIGINT       NOT NULL UNIQUE,
+                  name         VARCHAR(255) NOT NULL DEFAULT '',
+                  applied_at   DATETIME(6)  NULL,
+                  execution_ms BIGINT       NOT NULL DEFAULT 0,
+                  checksum     VARCHAR(64)  NOT NULL DEFAULT '',
+                  applied_by   VARCHAR(255) NOT NULL DEFAULT '',
                   PRIMARY KEY (id)
                 )`
 }
 
-func (m Mysql) GetMigrationSql() string {
-	return `SELECT migration_id FROM gomigrate WHERE migration_id = ?`
+func (m Mysql) SelectMigrationTableColumnsSql() string {
+	return "SELECT column_name FROM information_schema.columns WHERE table_name = ? AND table_schema = COALESCE(NULLIF(?, ''), DATABASE())"
 }
 
-func (m Mysql) MigrationLogInsertSql() string {
-	return "INSERT INTO gomigrate (migration_id) values (?)"
+func (m Mysql) AddMigrationTableColumnSql(table, column string) string {
+	switch column {
+	case "applied_at":
+		return "ALTER TABLE " + table + " ADD COLUMN applied_at DATETIME(6) NULL"
+	case "execution_ms":
+		return "ALTER TABLE " + table + " ADD COLUMN execution_ms BIGINT NOT NULL DEFAULT 0"
+	case "checksum":
+		return "ALTER TABLE " + table + " ADD COLUMN checksum VARCHAR(64) NOT NULL DEFAULT ''"
+	default:
+		return "ALTER TABLE " + table + " ADD COLUMN " + column + " VARCHAR(255) NOT NULL DEFAULT ''"
+	}
 }
 
-func (m Mysql) MigrationLogDeleteSql() string {
-	return "DELETE FROM gomigrate WHERE migration_id = ?"
+// Deprecated: use SelectAppliedMigrationIdsSql.
+func (m Mysql) GetMigrationSql(table string) string {
+	return `SELECT migration_id FROM ` + table + ` WHERE migration_id = ?`
 }
 
-func (m Mysql) GetMigrationCommands(sql string) []string {
-	delimiter := ";"
-	// we look at the first line of the migration for `delimiter foo`.
-	// If found, we strip the line off, unquote the value, and use it as the delimiter
-	if strings.HasPrefix(sql, "delimiter ") {
-		delimiterOffset := len("delimiter ")
-		contentSplit := strings.SplitN(sql[delimiterOffset:], "\n", 2)
-
-		delimiter = strings.TrimSpace(contentSplit[0])
-
-		if len(contentSplit) > 1 {
-			sql = contentSplit[1]
-		} else {
-			sql = ""
-		}
-
-		delimiterUnquoted, err := strconv.Unquote(delimiter)
-		if err == nil {
-			delimiter = delimiterUnquoted
-		}
-	}
+func (m Mysql) SelectAppliedMigrationIdsSql(table string) string {
+	return "SELECT migration_id FROM " + table
+}
+
+func (m Mysql) SelectMigrationLogSql(table string) string {
+	return "SELECT migration_id, name, applied_at, checksum FROM " + table + " ORDER BY migration_id"
+}
+
+func (m Mysql) MigrationLogInsertSql(table string) string {
+	return "INSERT INTO " + table + " (migration_id, name, applied_at, execution_ms, checksum, applied_by) values (?, ?, ?, ?, ?, ?)"
+}
+
+func (m Mysql) MigrationLogDeleteSql(table string) string {
+	return "DELETE FROM " + table + " WHERE migration_id = ?"
+}
+
+func (m Mysql) CreateRepeatableTableSql(table string) string {
+	return `CREATE TABLE ` + table + ` (
+                  name       VARCHAR(255) NOT NULL,
+                  checksum   VARCHAR(64)  NOT NULL DEFAULT '',
+                  applied_at DATETIME(6)  NULL,
+                  applied_by VARCHAR(255) NOT NULL DEFAULT '',
+                  PRIMARY KEY (name)
+                )`
+}
+
+func (m Mysql) SelectRepeatableChecksumsSql(table string) string {
+	return "SELECT name, checksum FROM " + table
+}
+
+func (m Mysql) RepeatableInsertSql(table string) string {
+	return "INSERT INTO " + table + " (name, checksum, applied_at, applied_by) values (?, ?, ?, ?)"
+}
+
+func (m Mysql) RepeatableDeleteSql(table string) string {
+	return "DELETE FROM " + table + " WHERE name = ?"
+}
 
-	return strings.Split(sql, delimiter)
+func (m Mysql) GetMigrationCommands(sql string) []string {
+	return splitStatements(sql, splitOptions{
+		delimiter:          ";",
+		backticks:          true,
+		hashComments:       true,
+		backslashEscapes:   true,
+		delimiterDirective: true,
+		compoundStatements: true,
+	})
 }
 
 // MARIADB
@@ -115,61 +252,183 @@ type Mariadb struct {
 
 type Sqlite3 struct{}
 
+func (s Sqlite3) QuoteTable(schema, name string) string {
+	return quoteTable(schema, name, `"`, `"`)
+}
+
 func (s Sqlite3) SelectMigrationTableSql() string {
-	return "SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?"
+	return "SELECT DISTINCT ?1 FROM pragma_table_info(?1, COALESCE(NULLIF(?2, ''), 'main'))"
 }
 
-func (s Sqlite3) CreateMigrationTableSql() string {
-	return `CREATE TABLE gomigrate (
+func (s Sqlite3) CreateMigrationTableSql(table string) string {
+	return `CREATE TABLE ` + table + ` (
   id INTEGER PRIMARY KEY,
-  migration_id INTEGER NOT NULL UNIQUE
+  migration_id INTEGER NOT NULL UNIQUE,
+  name TEXT NOT NULL DEFAULT '',
+  applied_at TIMESTAMP NULL,
+  execution_ms INTEGER NOT NULL DEFAULT 0,
+  checksum TEXT NOT NULL DEFAULT '',
+  applied_by TEXT NOT NULL DEFAULT ''
+)`
+}
+
+func (s Sqlite3) SelectMigrationTableColumnsSql() string {
+	return "SELECT name FROM pragma_table_info(?1, COALESCE(NULLIF(?2, ''), 'main'))"
+}
+
+func (s Sqlite3) AddMigrationTableColumnSql(table, column string) string {
+	switch column {
+	case "applied_at":
+		return "ALTER TABLE " + table + " ADD COLUMN applied_at TIMESTAMP NULL"
+	case "execution_ms":
+		return "ALTER TABLE " + table + " ADD COLUMN execution_ms INTEGER NOT NULL DEFAULT 0"
+	default:
+		return "ALTER TABLE " + table + " ADD COLUMN " + column + " TEXT NOT NULL DEFAULT ''"
+	}
+}
+
+// Deprecated: use SelectAppliedMigrationIdsSql.
+func (s Sqlite3) GetMigrationSql(table string) string {
+	return "SELECT migration_id FROM " + table + " WHERE migration_id = ?"
+}
+
+func (s Sqlite3) SelectAppliedMigrationIdsSql(table string) string {
+	return "SELECT migration_id FROM " + table
+}
+
+func (s Sqlite3) SelectMigrationLogSql(table string) string {
+	return "SELECT migration_id, name, applied_at, checksum FROM " + table + " ORDER BY migration_id"
+}
+
+func (s Sqlite3) MigrationLogInsertSql(table string) string {
+	return "INSERT INTO " + table + " (migration_id, name, applied_at, execution_ms, checksum, applied_by) values (?, ?, ?, ?, ?, ?)"
+}
+
+func (s Sqlite3) MigrationLogDeleteSql(table string) string {
+	return "DELETE FROM " + table + " WHERE migration_id = ?"
+}
+
+func (s Sqlite3) CreateRepeatableTableSql(table string) string {
+	return `CREATE TABLE ` + table + ` (
+  name TEXT PRIMARY KEY,
+  checksum TEXT NOT NULL DEFAULT '',
+  applied_at TIMESTAMP NULL,
+  applied_by TEXT NOT NULL DEFAULT ''
 )`
 }
 
-func (s Sqlite3) GetMigrationSql() string {
-	return "SELECT migration_id FROM gomigrate WHERE migration_id = ?"
+func (s Sqlite3) SelectRepeatableChecksumsSql(table string) string {
+	return "SELECT name, checksum FROM " + table
 }
 
-func (s Sqlite3) MigrationLogInsertSql() string {
-	return "INSERT INTO gomigrate (migration_id) values (?)"
+func (s Sqlite3) RepeatableInsertSql(table string) string {
+	return "INSERT INTO " + table + " (name, checksum, applied_at, applied_by) values (?, ?, ?, ?)"
 }
 
-func (s Sqlite3) MigrationLogDeleteSql() string {
-	return "DELETE FROM gomigrate WHERE migration_id = ?"
+func (s Sqlite3) RepeatableDeleteSql(table string) string {
+	return "DELETE FROM " + table + " WHERE name = ?"
 }
 
 func (s Sqlite3) GetMigrationCommands(sql string) []string {
-	return []string{sql}
+	return splitStatements(sql, splitOptions{
+		delimiter:     ";",
+		backticks:     true,
+		brackets:      true,
+		triggerBlocks: true,
+	})
 }
 
 // MSSQL
 
 type Mssql struct{}
 
+func (m Mssql) QuoteTable(schema, name string) string {
+	return quoteTable(schema, name, "[", "]")
+}
+
 func (m Mssql) SelectMigrationTableSql() string {
-  return "SELECT table_name FROM information_schema.tables WHERE table_name = ?"
+	return "SELECT table_name FROM information_schema.tables WHERE table_name = ? AND table_schema = COALESCE(NULLIF(?, ''), SCHEMA_NAME())"
 }
 
-func (m Mssql) CreateMigrationTableSql() string {
-  return `CREATE TABLE gomigrate (
+func (m Mssql) CreateMigrationTableSql(table string) string {
+	return `CREATE TABLE ` + table + ` (
                   id           INT          NOT NULL IDENTITY,
                   migration_id BIGINT       NOT NULL UNIQUE,
+                  name         VARCHAR(255) NOT NULL DEFAULT '',
+                  applied_at   DATETIME2    NULL,
+                  execution_ms BIGINT       NOT NULL DEFAULT 0,
+                  checksum     VARCHAR(64)  NOT NULL DEFAULT '',
+                  applied_by   VARCHAR(255) NOT NULL DEFAULT '',
                   PRIMARY KEY (id)
                 )`
 }
 
-func (m Mssql) GetMigrationSql() string {
-  return `SELECT migration_id FROM gomigrate WHERE migration_id = ?`
+func (m Mssql) SelectMigrationTableColumnsSql() string {
+	return "SELECT column_name FROM information_schema.columns WHERE table_name = ? AND table_schema = COALESCE(NULLIF(?, ''), SCHEMA_NAME())"
+}
+
+func (m Mssql) AddMigrationTableColumnSql(table, column string) string {
+	switch column {
+	case "applied_at":
+		return "ALTER TABLE " + table + " ADD applied_at DATETIME2 NULL"
+	case "execution_ms":
+		return "ALTER TABLE " + table + " ADD execution_ms BIGINT NOT NULL DEFAULT 0"
+	case "checksum":
+		return "ALTER TABLE " + table + " ADD checksum VARCHAR(64) NOT NULL DEFAULT ''"
+	default:
+		return "ALTER TABLE " + table + " ADD " + column + " VARCHAR(255) NOT NULL DEFAULT ''"
+	}
+}
+
+// Deprecated: use SelectAppliedMigrationIdsSql.
+func (m Mssql) GetMigrationSql(table string) string {
+	return `SELECT migration_id FROM ` + table + ` WHERE migration_id = ?`
+}
+
+func (m Mssql) SelectAppliedMigrationIdsSql(table string) string {
+	return "SELECT migration_id FROM " + table
+}
+
+func (m Mssql) SelectMigrationLogSql(table string) string {
+	return "SELECT migration_id, name, applied_at, checksum FROM " + table + " ORDER BY migration_id"
+}
+
+func (m Mssql) MigrationLogInsertSql(table string) string {
+	return "INSERT INTO " + table + " (migration_id, name, applied_at, execution_ms, checksum, applied_by) values (?, ?, ?, ?, ?, ?)"
+}
+
+func (m Mssql) MigrationLogDeleteSql(table string) string {
+	return "DELETE FROM " + table + " WHERE migration_id = ?"
+}
+
+func (m Mssql) CreateRepeatableTableSql(table string) string {
+	return `CREATE TABLE ` + table + ` (
+                  name       VARCHAR(255) NOT NULL,
+                  checksum   VARCHAR(64)  NOT NULL DEFAULT '',
+                  applied_at DATETIME2    NULL,
+                  applied_by VARCHAR(255) NOT NULL DEFAULT '',
+                  PRIMARY KEY (name)
+                )`
+}
+
+func (m Mssql) SelectRepeatableChecksumsSql(table string) string {
+	return "SELECT name, checksum FROM " + table
 }
 
-func (m Mssql) MigrationLogInsertSql() string {
-  return "INSERT INTO gomigrate (migration_id) values (?)"
+func (m Mssql) RepeatableInsertSql(table string) string {
+	return "INSERT INTO " + table + " (name, checksum, applied_at, applied_by) values (?, ?, ?, ?)"
 }
 
-func (m Mssql) MigrationLogDeleteSql() string {
-  return "DELETE FROM gomigrate WHERE migration_id = ?"
+func (m Mssql) RepeatableDeleteSql(table string) string {
+	return "DELETE FROM " + table + " WHERE name = ?"
 }
 
+// GetMigrationCommands splits sql into the batches separated by GO lines,
+// as sqlcmd does.  Statements within a batch are sent together.
 func (m Mssql) GetMigrationCommands(sql string) []string {
-  return []string{sql}
+	return splitStatements(sql, splitOptions{
+		brackets:       true,
+		nestedComments: true,
+		batchSeparator: true,
+	})
 }
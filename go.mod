@@ -1,6 +1,6 @@
 module github.com/derkan/gomigrate
 
-go 1.15
+go 1.21
 
 require (
 	github.com/denisenkom/go-mssqldb v0.9.0
@@ -8,3 +8,8 @@ require (
 	github.com/lib/pq v1.9.0
 	github.com/mattn/go-sqlite3 v1.14.6
 )
+
+require (
+	github.com/golang-sql/civil v0.0.0-20190719163853-cb61b32ac6fe // indirect
+	golang.org/x/crypto v0.0.0-20190325154230-a5d413f7728c // indirect
+)
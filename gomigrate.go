@@ -3,27 +3,51 @@
 package gomigrate
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"io/fs"
 	"log"
+	"log/slog"
+	"math"
 	"os"
+	"os/user"
 	"sort"
+	"strings"
+	"time"
 )
 
-type migrationType string
+// MigrationType is the direction a migration is applied in.
+type MigrationType string
 
 const (
 	migrationTableName = "gomigrate"
-	upMigration        = migrationType("up")
-	downMigration      = migrationType("down")
+	UpMigration        = MigrationType("up")
+	DownMigration      = MigrationType("down")
 )
 
+// Columns added to the migration table after the original id/migration_id
+// schema.  Existing tables are upgraded by adding any that are missing.
+var migrationTableColumns = []string{"name", "applied_at", "execution_ms", "checksum", "applied_by"}
+
 var (
 	InvalidMigrationFile  = errors.New("Invalid migration file")
 	InvalidMigrationPair  = errors.New("Invalid pair of migration files")
 	InvalidMigrationType  = errors.New("Invalid migration type")
 	ErrDuplicateMigration = errors.New("Duplicate migrations found")
+	ErrMigrationNotFound  = errors.New("Migration not found")
+	ErrChecksumMismatch   = errors.New("Applied migrations have changed")
+	ErrOutOfOrder         = errors.New("Pending migrations are older than applied ones")
+	// ErrNoTransactionMigration is returned when migrating in a single
+	// transaction includes a migration that can't run in one.
+	ErrNoTransactionMigration = errors.New("Migration can't run in a transaction")
+	// ErrAlreadyMigrated is returned by Baseline when migrations are
+	// already recorded.
+	ErrAlreadyMigrated = errors.New("Migrations have already been applied")
+	// ErrCreateMigrationTable wraps the error the database fails to create
+	// the migration table with.
+	ErrCreateMigrationTable = errors.New("Error creating migration table")
 )
 
 // Migrator contains the information needed to migrate a database schema.
@@ -32,7 +56,54 @@ type Migrator struct {
 	MigrationsPath string
 	dbAdapter      Migratable
 	migrations     map[uint64]*Migration
+	repeatables    map[string]*Migration
 	Logger         Logger
+	// SlogLogger, if set, receives structured log records in place of
+	// Logger.  Records about a migration carry its migration_id, name and
+	// direction.
+	SlogLogger *slog.Logger
+	// LockTimeout bounds how long Migrate, MigrateTo and RollbackN wait
+	// for the migration lock held by another process.  Zero waits until
+	// the context is done.
+	LockTimeout time.Duration
+	// DisableLocking skips taking the migration lock, for adapters that
+	// implement Locker.
+	DisableLocking bool
+	// VerifyChecksums makes Migrate and MigrateTo refuse to run while
+	// Verify reports applied migrations that have changed.
+	VerifyChecksums bool
+	// TableName is the name of the table migrations are recorded in,
+	// "gomigrate" if empty.  Services sharing a database can use different
+	// tables to keep separate migration histories.
+	TableName string
+	// Schema is the schema the migration table is in, the connection's
+	// current schema if empty.
+	Schema string
+	// AllowOutOfOrder lets Migrate and MigrateTo apply pending migrations
+	// older than the newest applied one, as happens with timestamp ids
+	// when branches are merged.  Otherwise they fail with ErrOutOfOrder.
+	AllowOutOfOrder bool
+	// TemplateData, if set, renders the sql of every migration as a
+	// text/template with it as the data before it's split into statements.
+	// The env function returns the value of an environment variable, as in
+	// {{env "ROLE"}}.  Checksums are of the rendered sql.
+	TemplateData map[string]string
+	// StrictTemplates makes rendering fail on keys missing from
+	// TemplateData and on unset environment variables, instead of
+	// rendering them as empty strings.
+	StrictTemplates bool
+	// SingleTransaction makes Migrate apply every pending migration in one
+	// transaction, so a failure rolls back all of them.  It's only atomic
+	// on databases with transactional DDL, such as PostgreSQL, SQLite and
+	// SQL Server, and fails with ErrNoTransactionMigration if a pending
+	// migration can't run in a transaction.  Repeatable migrations still
+	// run afterwards in transactions of their own.
+	SingleTransaction bool
+	// Hooks, if set, are called as migrations are applied.
+	Hooks Hooks
+	// AppliedBy is recorded in the migration table for every migration
+	// applied.  It defaults to the current user and host name.
+	AppliedBy string
 }
 
 // Logger represents the standard logging interface allows different logging
@@ -41,34 +112,46 @@ type Logger interface {
 	Print(v ...interface{})
 	Printf(format string, v ...interface{})
 	Println(v ...interface{})
-	Fatalf(format string, v ...interface{})
 }
 
 // MigrationTableExists returns true if the migration table already exists.
 func (m *Migrator) MigrationTableExists() (bool, error) {
-	row := m.DB.QueryRow(m.dbAdapter.SelectMigrationTableSql(), migrationTableName)
+	return m.MigrationTableExistsContext(context.Background())
+}
+
+// MigrationTableExistsContext is like MigrationTableExists but honours the
+// cancellation and deadline of the given context.
+func (m *Migrator) MigrationTableExistsContext(ctx context.Context) (bool, error) {
+	row := m.DB.QueryRowContext(ctx, m.dbAdapter.SelectMigrationTableSql(), m.tableName(), m.Schema)
 	var tableName string
 	err := row.Scan(&tableName)
 	if err == sql.ErrNoRows {
-		m.Logger.Print("Migrations table not found")
+		m.log().Info("Migrations table not found", "table", m.table())
 		return false, nil
 	}
 	if err != nil {
-		m.Logger.Printf("Error checking for migration table: %v", err)
+		m.log().Error("Error checking for migration table", "table", m.table(), "error", err)
 		return false, err
 	}
-	m.Logger.Print("Migrations table found")
+	m.log().Info("Migrations table found", "table", m.table())
 	return true, nil
 }
 
 // CreateMigrationsTable creates the migrations table if it doesn't exist.
 func (m *Migrator) CreateMigrationsTable() error {
-	_, err := m.DB.Exec(m.dbAdapter.CreateMigrationTableSql())
+	return m.CreateMigrationsTableContext(context.Background())
+}
+
+// CreateMigrationsTableContext is like CreateMigrationsTable but honours the
+// cancellation and deadline of the given context.
+func (m *Migrator) CreateMigrationsTableContext(ctx context.Context) error {
+	_, err := m.DB.ExecContext(ctx, m.dbAdapter.CreateMigrationTableSql(m.table()))
 	if err != nil {
-		m.Logger.Fatalf("Error creating migrations table: %v", err)
+		m.log().Error("Error creating migrations table", "table", m.table(), "error", err)
+		return fmt.Errorf("table: %s, err: %w: %w", m.table(), ErrCreateMigrationTable, err)
 	}
 
-	m.Logger.Printf("Created migrations table: %s", migrationTableName)
+	m.log().Info("Created migrations table", "table", m.table())
 
 	return nil
 }
@@ -78,16 +161,26 @@ func (m *Migrator) CreateMigrationsTable() error {
 // connect to the database.  All changes happen in the Migrate() function.
 func NewMigratorWithMigrations(db *sql.DB, adapter Migratable, migrations []*Migration) (*Migrator, error) {
 	migrator := &Migrator{
-		DB:         db,
-		dbAdapter:  adapter,
-		migrations: make(map[uint64]*Migration),
-		Logger:     log.New(os.Stderr, "[gomigrate] ", log.LstdFlags),
+		DB:          db,
+		dbAdapter:   adapter,
+		migrations:  make(map[uint64]*Migration),
+		repeatables: make(map[string]*Migration),
+		Logger:      log.New(os.Stderr, "[gomigrate] ", log.LstdFlags),
+		LockTimeout: DefaultLockTimeout,
+		AppliedBy:   defaultAppliedBy(),
 	}
 	for _, m := range migrations {
 		m.Status = Inactive
 		if ok := m.Validate(); ok != nil {
 			return nil, ok
 		}
+		if m.Repeatable {
+			if _, ok := migrator.repeatables[m.Name]; ok {
+				return nil, fmt.Errorf("name: %s, err: %w", m.Name, ErrDuplicateMigration)
+			}
+			migrator.repeatables[m.Name] = m
+			continue
+		}
 		if _, ok := migrator.migrations[m.ID]; ok {
 			return nil, fmt.Errorf("id: %d, err: %w", m.ID, ErrDuplicateMigration)
 		}
@@ -118,25 +211,255 @@ func NewMigratorWithLogger(db *sql.DB, adapter Migratable, migrationsPath string
 	return m, nil
 }
 
+// NewMigratorFromFS loads migrations from the given directory of a file
+// system, such as an embed.FS, and returns a new migrator using the given
+// Logger.
+func NewMigratorFromFS(db *sql.DB, adapter Migratable, fsys fs.FS, dir string, logger Logger) (*Migrator, error) {
+	migrations, err := MigrationsFromFS(fsys, dir, logger)
+	if err != nil {
+		return nil, err
+	}
+	m, err := NewMigratorWithMigrations(db, adapter, migrations)
+	if err != nil {
+		return nil, err
+	}
+	m.Logger = logger
+
+	return m, nil
+}
+
 // Migrate runs the given migrations against the database.
 // It will also create the migration meta table if needed and will only run
-// migrations that haven't already been run.
+// migrations that haven't already been run.  Repeatable migrations that
+// changed since they were last applied run afterwards, in name order.  If
+// the adapter implements Locker the migration lock is held while migrating.
 func (m *Migrator) Migrate() error {
-	// Create the migrations table if it doesn't exist.
-	tableExists, err := m.MigrationTableExists()
-	if err != nil {
+	return m.MigrateContext(context.Background())
+}
+
+// MigrateContext is like Migrate but honours the cancellation and deadline of
+// the given context.  A cancelled context aborts the running migration and
+// rolls back its transaction.
+func (m *Migrator) MigrateContext(ctx context.Context) error {
+	return m.runLocked(ctx, func() error {
+		return m.migrate(ctx)
+	})
+}
+
+func (m *Migrator) migrate(ctx context.Context) error {
+	if err := m.ensureMigrationsTable(ctx); err != nil {
 		return err
 	}
-	if !tableExists {
-		if err := m.CreateMigrationsTable(); err != nil {
+	if err := m.getMigrationStatuses(ctx); err != nil {
+		return err
+	}
+	if err := m.refuseChecksumMismatches(ctx); err != nil {
+		return err
+	}
+	if err := m.refuseOutOfOrder(math.MaxUint64); err != nil {
+		return err
+	}
+	if m.SingleTransaction {
+		if err := m.migrateInTransaction(ctx, m.Migrations(Inactive)); err != nil {
+			return err
+		}
+		return m.applyRepeatables(ctx)
+	}
+	for _, migration := range m.Migrations(Inactive) {
+		if err := m.ApplyMigrationContext(ctx, migration, UpMigration); err != nil {
 			return err
 		}
 	}
-	if err := m.getMigrationStatuses(); err != nil {
+
+	return m.applyRepeatables(ctx)
+}
+
+// Applies migrations in a single transaction, so either all of them are
+// applied or none are.  A failure to commit is reported against the last
+// migration.
+func (m *Migrator) migrateInTransaction(ctx context.Context, migrations []*Migration) error {
+	if len(migrations) == 0 {
+		return nil
+	}
+	steps := make([]*migrationStep, len(migrations))
+	for i, migration := range migrations {
+		step, err := m.migrationStep(migration, UpMigration)
+		if err == nil && step.noTransaction {
+			err = ErrNoTransactionMigration
+		}
+		if err != nil {
+			return newMigrationError(migration, UpMigration, nil, -1, err)
+		}
+		steps[i] = step
+	}
+
+	m.log().Info("Applying migrations in a single transaction", "count", len(migrations))
+	transaction, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		m.log().Error("Error opening transaction", "error", err)
+		return newMigrationError(migrations[0], UpMigration, steps[0], -1, err)
+	}
+	start := time.Now()
+	// Time taken by each migration run so far.  Their AfterEach hooks
+	// wait for the transaction to end, so they report whether the
+	// migration was actually applied.
+	var elapsed []time.Duration
+	var hookErr error
+	var migrationErr *MigrationError
+	for i, migration := range migrations {
+		if m.Hooks != nil {
+			if hookErr = m.Hooks.BeforeEach(ctx, migration, UpMigration); hookErr != nil {
+				break
+			}
+		}
+		m.migrationLogger(migration, UpMigration).Info("Applying migration")
+		migrationStart := time.Now()
+		migrationErr = m.applyInTransaction(ctx, transaction, migration, UpMigration, steps[i])
+		elapsed = append(elapsed, time.Since(migrationStart))
+		if migrationErr != nil {
+			break
+		}
+	}
+	switch {
+	case hookErr != nil:
+		// As with ApplyMigration, the hook's error is returned as it is.
+		if rollbackErr := transaction.Rollback(); rollbackErr != nil {
+			m.log().Error("Error rolling back transaction", "error", rollbackErr)
+		}
+		err = hookErr
+	case migrationErr != nil:
+		err = m.rollback(m.log(), transaction, migrationErr)
+	default:
+		if commitErr := transaction.Commit(); commitErr != nil {
+			m.log().Error("Error commiting transaction", "error", commitErr)
+			last := len(migrations) - 1
+			err = newMigrationError(migrations[last], UpMigration, steps[last], -1, commitErr)
+		}
+	}
+
+	if m.Hooks != nil {
+		for i, duration := range elapsed {
+			m.Hooks.AfterEach(ctx, migrations[i], UpMigration, duration, err)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	for _, migration := range migrations {
+		setMigrationStatus(migration, UpMigration)
+	}
+	m.log().Info("Applied migrations", "count", len(migrations), "duration", time.Since(start))
+	return nil
+}
+
+// PlannedMigration describes a migration Migrate would apply.
+type PlannedMigration struct {
+	Migration *Migration
+	Direction MigrationType
+	Commands  []string
+	// Func reports whether a Go function runs after the commands.
+	Func bool
+	// NoTransaction reports whether the commands run outside a
+	// transaction.
+	NoTransaction bool
+}
+
+// Plan returns the migrations Migrate would apply, in order, along with the
+// commands that would be executed for each.  It fails as Migrate would,
+// with ErrChecksumMismatch or ErrOutOfOrder, when Migrate would refuse to
+// run.  It only reads from the database: neither the migration meta table
+// nor any transaction is created.
+func (m *Migrator) Plan() ([]*PlannedMigration, error) {
+	return m.PlanContext(context.Background())
+}
+
+// PlanContext is like Plan but honours the cancellation and deadline of the
+// given context.
+func (m *Migrator) PlanContext(ctx context.Context) ([]*PlannedMigration, error) {
+	tableExists, err := m.MigrationTableExistsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if tableExists {
+		if err := m.getMigrationStatuses(ctx); err != nil {
+			return nil, err
+		}
+		// Refuse what Migrate would refuse, so the plan is one it runs.
+		if err := m.refuseChecksumMismatches(ctx); err != nil {
+			return nil, err
+		}
+		if err := m.refuseOutOfOrder(math.MaxUint64); err != nil {
+			return nil, err
+		}
+	}
+
+	repeatables, err := m.changedRepeatables(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan []*PlannedMigration
+	for _, migration := range append(m.Migrations(Inactive), repeatables...) {
+		step, err := m.migrationStep(migration, UpMigration)
+		if err != nil {
+			return nil, fmt.Errorf("id: %d, err: %w", migration.ID, err)
+		}
+		plan = append(plan, &PlannedMigration{
+			Migration:     migration,
+			Direction:     UpMigration,
+			Commands:      step.commands,
+			Func:          step.fn != nil,
+			NoTransaction: step.noTransaction,
+		})
+	}
+
+	return plan, nil
+}
+
+// MigrateTo migrates the database to the given migration id.  Migrations
+// newer than id that are applied are rolled back, newest first, and
+// migrations up to and including id that are not applied are run, oldest
+// first.  An id of 0 rolls back every migration.
+func (m *Migrator) MigrateTo(id uint64) error {
+	return m.MigrateToContext(context.Background(), id)
+}
+
+// MigrateToContext is like MigrateTo but honours the cancellation and
+// deadline of the given context.
+func (m *Migrator) MigrateToContext(ctx context.Context, id uint64) error {
+	return m.runLocked(ctx, func() error {
+		return m.migrateTo(ctx, id)
+	})
+}
+
+func (m *Migrator) migrateTo(ctx context.Context, id uint64) error {
+	if _, ok := m.migrations[id]; id != 0 && !ok {
+		return fmt.Errorf("id: %d, err: %w", id, ErrMigrationNotFound)
+	}
+	if err := m.ensureMigrationsTable(ctx); err != nil {
+		return err
+	}
+	if err := m.getMigrationStatuses(ctx); err != nil {
 		return err
 	}
+	if err := m.refuseChecksumMismatches(ctx); err != nil {
+		return err
+	}
+	if err := m.refuseOutOfOrder(id); err != nil {
+		return err
+	}
+
+	applied := m.Migrations(Active)
+	for i := len(applied) - 1; i >= 0 && applied[i].ID > id; i-- {
+		if err := m.ApplyMigrationContext(ctx, applied[i], DownMigration); err != nil {
+			return err
+		}
+	}
 	for _, migration := range m.Migrations(Inactive) {
-		if err := m.ApplyMigration(migration, upMigration); err != nil {
+		if migration.ID > id {
+			break
+		}
+		if err := m.ApplyMigrationContext(ctx, migration, UpMigration); err != nil {
 			return err
 		}
 	}
@@ -144,29 +467,171 @@ func (m *Migrator) Migrate() error {
 	return nil
 }
 
-// Queries the migration table to determine the status of each
-// migration.
-func (m *Migrator) getMigrationStatuses() error {
-	for _, migration := range m.migrations {
-		row := m.DB.QueryRow(m.dbAdapter.GetMigrationSql(), migration.ID)
-		var mid uint64
-		err := row.Scan(&mid)
-		if err == sql.ErrNoRows {
+// Returns the name of the migration table.
+func (m *Migrator) tableName() string {
+	if m.TableName == "" {
+		return migrationTableName
+	}
+	return m.TableName
+}
+
+// Returns the migration table, quoted and qualified for the adapter.
+func (m *Migrator) table() string {
+	return m.dbAdapter.QuoteTable(m.Schema, m.tableName())
+}
+
+// Creates the migration meta table if it doesn't exist yet, or upgrades it
+// if it was created by an older version.
+func (m *Migrator) ensureMigrationsTable(ctx context.Context) error {
+	tableExists, err := m.MigrationTableExistsContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !tableExists {
+		return m.CreateMigrationsTableContext(ctx)
+	}
+	return m.upgradeMigrationsTable(ctx)
+}
+
+// Adds the columns missing from a migration table created by an older
+// version.
+func (m *Migrator) upgradeMigrationsTable(ctx context.Context) error {
+	columns, err := m.migrationTableColumnSet(ctx)
+	if err != nil {
+		return err
+	}
+	for _, column := range migrationTableColumns {
+		if columns[column] {
 			continue
 		}
-		if err != nil {
-			m.Logger.Printf(
-				"Error getting migration status for %s: %v",
-				migration.Name,
-				err,
-			)
+		if _, err := m.DB.ExecContext(ctx, m.dbAdapter.AddMigrationTableColumnSql(m.table(), column)); err != nil {
+			m.log().Error("Error adding column to migrations table", "table", m.table(), "column", column, "error", err)
 			return err
 		}
-		migration.Status = Active
+		m.log().Info("Added column to migrations table", "table", m.table(), "column", column)
 	}
 	return nil
 }
 
+// Returns the lower cased names of the columns of the migration table.
+func (m *Migrator) migrationTableColumnSet(ctx context.Context) (map[string]bool, error) {
+	rows, err := m.DB.QueryContext(ctx, m.dbAdapter.SelectMigrationTableColumnsSql(), m.tableName(), m.Schema)
+	if err != nil {
+		m.log().Error("Error listing migration table columns", "table", m.table(), "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+	columns := map[string]bool{}
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns[strings.ToLower(column)] = true
+	}
+	return columns, rows.Err()
+}
+
+// Returns user@host for the current process, or whichever half is known.
+func defaultAppliedBy() string {
+	var name string
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		if name == "" {
+			return host
+		}
+		name = name + "@" + host
+	}
+	return name
+}
+
+// Queries the migration table to determine the status of each
+// migration.
+func (m *Migrator) getMigrationStatuses(ctx context.Context) error {
+	rows, err := m.DB.QueryContext(ctx, m.dbAdapter.SelectAppliedMigrationIdsSql(m.table()))
+	if err != nil {
+		m.log().Error("Error getting migration statuses", "table", m.table(), "error", err)
+		return err
+	}
+	defer rows.Close()
+
+	applied := map[uint64]bool{}
+	for rows.Next() {
+		var id uint64
+		if err := rows.Scan(&id); err != nil {
+			m.log().Error("Error getting migration statuses", "table", m.table(), "error", err)
+			return err
+		}
+		applied[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		m.log().Error("Error getting migration statuses", "table", m.table(), "error", err)
+		return err
+	}
+
+	for id, migration := range m.migrations {
+		if applied[id] {
+			migration.Status = Active
+		} else {
+			migration.Status = Inactive
+		}
+	}
+	return nil
+}
+
+// A row of the migration table.
+type migrationLogEntry struct {
+	ID        uint64
+	Name      string
+	AppliedAt nullTime
+	Checksum  string
+}
+
+// Reads every row of the migration table, ordered by migration id.  A table
+// created by an older version is read as it is, with empty values for the
+// columns it lacks, as upgrading it is left to migrations holding the lock.
+func (m *Migrator) migrationLog(ctx context.Context) ([]*migrationLogEntry, error) {
+	columns, err := m.migrationTableColumnSet(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query := m.dbAdapter.SelectMigrationLogSql(m.table())
+	if !columns["name"] || !columns["applied_at"] || !columns["checksum"] {
+		query = legacyMigrationLogSql(m.table(), columns)
+	}
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		m.log().Error("Error reading migration log", "table", m.table(), "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*migrationLogEntry
+	for rows.Next() {
+		entry := &migrationLogEntry{}
+		if err := rows.Scan(&entry.ID, &entry.Name, &entry.AppliedAt, &entry.Checksum); err != nil {
+			m.log().Error("Error reading migration log", "table", m.table(), "error", err)
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Returns a query like SelectMigrationLogSql for a migration table missing
+// some of the columns it reads, selecting an empty value in their place.
+func legacyMigrationLogSql(table string, columns map[string]bool) string {
+	column := func(name, empty string) string {
+		if columns[name] {
+			return name
+		}
+		return empty
+	}
+	return "SELECT migration_id, " + column("name", "''") + ", " + column("applied_at", "NULL") + ", " + column("checksum", "''") + " FROM " + table + " ORDER BY migration_id"
+}
+
 // Migrations returns a sorted list of migration ids for a given status. -1 returns
 // all migrations.
 func (m *Migrator) Migrations(status int) []*Migration {
@@ -190,94 +655,285 @@ func (m *Migrator) Migrations(status int) []*Migration {
 }
 
 // ApplyMigration applies a single migration in the given direction.
-func (m *Migrator) ApplyMigration(migration *Migration, mType migrationType) error {
-	m.Logger.Printf("Applying migration: %s", migration.Name)
-	var sql string
-	if mType == upMigration && migration.Up != "" {
-		sql = migration.Up
-	} else if mType == downMigration && migration.Down != "" {
-		sql = migration.Down
-	} else {
-		return InvalidMigrationType
+func (m *Migrator) ApplyMigration(migration *Migration, mType MigrationType) error {
+	return m.ApplyMigrationContext(context.Background(), migration, mType)
+}
+
+// ApplyMigrationContext is like ApplyMigration but honours the cancellation
+// and deadline of the given context.
+func (m *Migrator) ApplyMigrationContext(ctx context.Context, migration *Migration, mType MigrationType) error {
+	return m.withEachHooks(ctx, migration, mType, func() error {
+		return m.applyMigration(ctx, migration, mType)
+	})
+}
+
+// Runs fn, which applies a migration, calling the hooks around it.
+func (m *Migrator) withEachHooks(ctx context.Context, migration *Migration, mType MigrationType, fn func() error) error {
+	if m.Hooks == nil {
+		return fn()
 	}
-	transaction, err := m.DB.Begin()
-	if err != nil {
-		m.Logger.Printf("Error opening transaction: %v", err)
+	if err := m.Hooks.BeforeEach(ctx, migration, mType); err != nil {
 		return err
 	}
+	start := time.Now()
+	err := fn()
+	m.Hooks.AfterEach(ctx, migration, mType, time.Since(start), err)
+	return err
+}
 
-	// Certain adapters can not handle multiple sql commands in one file so we need the adapter to split up the command
-	commands := m.dbAdapter.GetMigrationCommands(string(sql))
+func (m *Migrator) applyMigration(ctx context.Context, migration *Migration, mType MigrationType) error {
+	logger := m.migrationLogger(migration, mType)
+	logger.Info("Applying migration")
+	step, err := m.migrationStep(migration, mType)
+	if err != nil {
+		return newMigrationError(migration, mType, nil, -1, err)
+	}
+	if step.noTransaction {
+		return m.applyWithoutTransaction(ctx, migration, mType, step)
+	}
+	transaction, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Error("Error opening transaction", "error", err)
+		return newMigrationError(migration, mType, step, -1, err)
+	}
 
 	// Perform the migration.
-	for _, cmd := range commands {
-		result, err := transaction.Exec(cmd)
+	start := time.Now()
+	if err := m.applyInTransaction(ctx, transaction, migration, mType, step); err != nil {
+		return m.rollback(logger, transaction, err)
+	}
+
+	// Commit and update the struct status.
+	if err := transaction.Commit(); err != nil {
+		logger.Error("Error commiting transaction", "error", err)
+		return newMigrationError(migration, mType, step, -1, err)
+	}
+	setMigrationStatus(migration, mType)
+	logger.Info("Applied migration", "duration", time.Since(start))
+
+	return nil
+}
+
+// Runs the statements and function of a migration in a transaction and
+// records it, leaving the transaction to be committed or rolled back.
+func (m *Migrator) applyInTransaction(ctx context.Context, transaction *sql.Tx, migration *Migration, mType MigrationType, step *migrationStep) *MigrationError {
+	logger := m.migrationLogger(migration, mType)
+	start := time.Now()
+	for i, cmd := range step.commands {
+		result, err := transaction.ExecContext(ctx, cmd)
 		if err != nil {
-			m.Logger.Printf("Error executing migration: ===err=== %v, ===sql=== %s", err, cmd)
-			if rollbackErr := transaction.Rollback(); rollbackErr != nil {
-				m.Logger.Printf("Error rolling back transaction: %v", rollbackErr)
-				return rollbackErr
-			}
-			return err
+			logger.Error("Error executing migration", "statement", i, "line", step.line(i), "sql", cmd, "error", err)
+			return newMigrationError(migration, mType, step, i, err)
 		}
 		if result != nil {
 			rowsAffected, err := result.RowsAffected()
 			if err != nil {
-				m.Logger.Printf("Error getting rows affected: %v", err)
-				if rollbackErr := transaction.Rollback(); rollbackErr != nil {
-					m.Logger.Printf("Error rolling back transaction: %v", rollbackErr)
-					return rollbackErr
-				}
-				return err
+				logger.Error("Error getting rows affected", "statement", i, "error", err)
+				return newMigrationError(migration, mType, step, i, err)
 			}
-			m.Logger.Printf("Rows affected: %v", rowsAffected)
+			logger.Debug("Executed statement", "statement", i, "rows_affected", rowsAffected)
+		}
+	}
+	if step.fn != nil {
+		if err := step.fn(ctx, transaction); err != nil {
+			logger.Error("Error executing migration function", "error", err)
+			return newMigrationError(migration, mType, step, -1, err)
 		}
 	}
 
 	// Log the event.
-	if mType == upMigration {
-		_, err = transaction.Exec(
-			m.dbAdapter.MigrationLogInsertSql(),
-			migration.ID,
-		)
+	if err := m.logMigration(ctx, transaction, migration, mType, time.Since(start)); err != nil {
+		logger.Error("Error logging migration", "error", err)
+		return newMigrationError(migration, mType, step, -1, err)
+	}
+	return nil
+}
+
+// Updates the status of a migration applied in the given direction.
+func setMigrationStatus(migration *Migration, mType MigrationType) {
+	if mType == UpMigration {
+		migration.Status = Active
 	} else {
-		_, err = transaction.Exec(
-			m.dbAdapter.MigrationLogDeleteSql(),
-			migration.ID,
-		)
+		migration.Status = Inactive
 	}
+}
+
+// Rolls back the transaction of a failed migration, recording any error doing
+// so in err.
+func (m *Migrator) rollback(logger *slog.Logger, transaction *sql.Tx, err *MigrationError) *MigrationError {
+	if rollbackErr := transaction.Rollback(); rollbackErr != nil {
+		logger.Error("Error rolling back transaction", "error", rollbackErr)
+		err.RollbackErr = rollbackErr
+	}
+	return err
+}
+
+// Applies a migration whose statements can't run inside a transaction, such
+// as CREATE INDEX CONCURRENTLY.  The statements run one at a time on a single
+// connection, and a failure leaves the ones before it applied, so they have
+// to be reverted by hand.  Once every statement has run, the migration
+// function, if any, and the migration log run in a transaction of their own.
+func (m *Migrator) applyWithoutTransaction(ctx context.Context, migration *Migration, mType MigrationType, step *migrationStep) error {
+	logger := m.migrationLogger(migration, mType)
+	logger.Info("Applying migration without a transaction")
+	conn, err := m.DB.Conn(ctx)
 	if err != nil {
-		m.Logger.Printf("Error logging migration: %v", err)
-		if rollbackErr := transaction.Rollback(); rollbackErr != nil {
-			m.Logger.Printf("Error rolling back transaction: %v", rollbackErr)
-			return rollbackErr
+		logger.Error("Error opening connection", "error", err)
+		return newMigrationError(migration, mType, step, -1, err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	for i, cmd := range step.commands {
+		result, err := conn.ExecContext(ctx, cmd)
+		if err != nil {
+			logger.Error("Error executing migration", "statement", i, "line", step.line(i), "sql", cmd, "error", err)
+			logger.Error("Migration ran without a transaction, the statements before the failed one were applied and must be reverted by hand",
+				"statements_applied", i, "statements", len(step.commands))
+			return newMigrationError(migration, mType, step, i, err)
+		}
+		if result != nil {
+			if rowsAffected, err := result.RowsAffected(); err == nil {
+				logger.Debug("Executed statement", "statement", i, "rows_affected", rowsAffected)
+			}
 		}
-		return err
 	}
 
-	// Commit and update the struct status.
-	if err := transaction.Commit(); err != nil {
-		m.Logger.Printf("Error commiting transaction: %v", err)
-		return err
+	transaction, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Error("Error opening transaction", "error", err)
+		return newMigrationError(migration, mType, step, -1, err)
 	}
-	if mType == upMigration {
-		migration.Status = Active
+	if step.fn != nil {
+		err = step.fn(ctx, transaction)
+	}
+	if err == nil {
+		err = m.logMigration(ctx, transaction, migration, mType, time.Since(start))
+	}
+	var migrationErr *MigrationError
+	if err == nil {
+		if err = transaction.Commit(); err != nil {
+			migrationErr = newMigrationError(migration, mType, step, -1, err)
+		}
 	} else {
-		migration.Status = Inactive
+		migrationErr = m.rollback(logger, transaction, newMigrationError(migration, mType, step, -1, err))
+	}
+	if migrationErr != nil {
+		logger.Error("Error recording migration", "error", err)
+		logger.Error("Migration ran without a transaction, all of its statements were applied but it isn't recorded")
+		return migrationErr
 	}
 
+	setMigrationStatus(migration, mType)
+	logger.Info("Applied migration", "duration", time.Since(start))
 	return nil
 }
 
+// Records a migration being applied in the given direction.
+func (m *Migrator) logMigration(ctx context.Context, transaction *sql.Tx, migration *Migration, mType MigrationType, elapsed time.Duration) error {
+	if migration.Repeatable {
+		return m.logRepeatable(ctx, transaction, migration)
+	}
+	if mType == UpMigration {
+		checksum, err := m.checksum(migration)
+		if err != nil {
+			return err
+		}
+		_, err = transaction.ExecContext(
+			ctx,
+			m.dbAdapter.MigrationLogInsertSql(m.table()),
+			migration.ID,
+			migration.Name,
+			time.Now().UTC(),
+			elapsed.Milliseconds(),
+			checksum,
+			m.AppliedBy,
+		)
+		return err
+	}
+	_, err := transaction.ExecContext(
+		ctx,
+		m.dbAdapter.MigrationLogDeleteSql(m.table()),
+		migration.ID,
+	)
+	return err
+}
+
+// The work needed to apply a migration in one direction.
+type migrationStep struct {
+	commands []string
+	// Line of the sql each command starts on.
+	lines         []int
+	fn            MigrationFunc
+	noTransaction bool
+}
+
+// Returns the line of the sql command i starts on, or 0 if unknown.
+func (s *migrationStep) line(i int) int {
+	if i < 0 || i >= len(s.lines) {
+		return 0
+	}
+	return s.lines[i]
+}
+
+// Returns the commands and function that apply a migration in the given
+// direction.  Either may be empty, but not both.
+func (m *Migrator) migrationStep(migration *Migration, mType MigrationType) (*migrationStep, error) {
+	var sql string
+	step := &migrationStep{noTransaction: migration.NoTransaction}
+	switch mType {
+	case UpMigration:
+		sql, step.fn = migration.Up, migration.UpFunc
+	case DownMigration:
+		sql, step.fn = migration.Down, migration.DownFunc
+	}
+	if sql == "" && step.fn == nil {
+		return nil, InvalidMigrationType
+	}
+	if sql == "" {
+		return step, nil
+	}
+	sql, err := m.renderSQL(migration, mType, sql)
+	if err != nil {
+		return nil, err
+	}
+	if hasNoTransactionHeader(sql) {
+		step.noTransaction = true
+	}
+
+	// Certain adapters can not handle multiple sql commands in one file so we need the adapter to split up the command
+	step.commands = m.dbAdapter.GetMigrationCommands(sql)
+	step.lines = statementLines(sql, step.commands)
+	return step, nil
+}
+
 // Rollback rolls back the last migration.
 func (m *Migrator) Rollback() error {
-	return m.RollbackN(1)
+	return m.RollbackContext(context.Background())
 }
 
-// RollbackN rolls back N migrations.
+// RollbackContext is like Rollback but honours the cancellation and deadline
+// of the given context.
+func (m *Migrator) RollbackContext(ctx context.Context) error {
+	return m.RollbackNContext(ctx, 1)
+}
+
+// RollbackN rolls back N migrations, or every applied one if there are fewer.
 func (m *Migrator) RollbackN(n int) error {
+	return m.RollbackNContext(context.Background(), n)
+}
+
+// RollbackNContext is like RollbackN but honours the cancellation and
+// deadline of the given context.
+func (m *Migrator) RollbackNContext(ctx context.Context, n int) error {
+	return m.runLocked(ctx, func() error {
+		return m.rollbackN(ctx, n)
+	})
+}
+
+func (m *Migrator) rollbackN(ctx context.Context, n int) error {
 	// checks the database for migration statuses
-	if err := m.getMigrationStatuses(); err != nil {
+	if err := m.getMigrationStatuses(ctx); err != nil {
 		return err
 	}
 
@@ -285,11 +941,14 @@ func (m *Migrator) RollbackN(n int) error {
 	if len(migrations) == 0 {
 		return nil
 	}
+	if n > len(migrations) {
+		n = len(migrations)
+	}
 
 	lastMigration := len(migrations) - 1 - n
 
 	for i := len(migrations) - 1; i != lastMigration; i-- {
-		if err := m.ApplyMigration(migrations[i], downMigration); err != nil {
+		if err := m.ApplyMigrationContext(ctx, migrations[i], DownMigration); err != nil {
 			return err
 		}
 	}
@@ -297,8 +956,44 @@ func (m *Migrator) RollbackN(n int) error {
 	return nil
 }
 
+// Redo rolls back the last applied migration and applies it again, holding
+// the migration lock throughout so no other process migrates in between.  It
+// does nothing if no migration is applied.
+func (m *Migrator) Redo() error {
+	return m.RedoContext(context.Background())
+}
+
+// RedoContext is like Redo but honours the cancellation and deadline of the
+// given context.
+func (m *Migrator) RedoContext(ctx context.Context) error {
+	return m.runLocked(ctx, func() error {
+		return m.redo(ctx)
+	})
+}
+
+func (m *Migrator) redo(ctx context.Context) error {
+	if err := m.getMigrationStatuses(ctx); err != nil {
+		return err
+	}
+	applied := m.Migrations(Active)
+	if len(applied) == 0 {
+		return nil
+	}
+	last := applied[len(applied)-1]
+	if err := m.ApplyMigrationContext(ctx, last, DownMigration); err != nil {
+		return err
+	}
+	return m.ApplyMigrationContext(ctx, last, UpMigration)
+}
+
 // RollbackAll rolls back all migrations.
 func (m *Migrator) RollbackAll() error {
+	return m.RollbackAllContext(context.Background())
+}
+
+// RollbackAllContext is like RollbackAll but honours the cancellation and
+// deadline of the given context.
+func (m *Migrator) RollbackAllContext(ctx context.Context) error {
 	migrations := m.Migrations(Active)
-	return m.RollbackN(len(migrations))
+	return m.RollbackNContext(ctx, len(migrations))
 }
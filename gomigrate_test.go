@@ -1,12 +1,23 @@
 package gomigrate
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	_ "github.com/denisenkom/go-mssqldb"
 	_ "github.com/go-sql-driver/mysql"
@@ -14,9 +25,13 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+//go:embed test_migrations
+var testMigrations embed.FS
+
 var (
 	db         *sql.DB
 	adapter    Migratable
+	testTable  string
 	dbType     string
 	nullLogger = log.New(ioutil.Discard, "", log.LstdFlags)
 )
@@ -30,6 +45,27 @@ func GetMigrator(test string) *Migrator {
 	return m
 }
 
+// Returns a migrator with n migrations, numbered from 1, each creating a
+// table named after prefix and its id.
+func newTableMigrator(t testing.TB, prefix string, n int) *Migrator {
+	t.Helper()
+	var migrations []*Migration
+	for i := 1; i <= n; i++ {
+		migrations = append(migrations, &Migration{
+			ID:   uint64(i),
+			Name: fmt.Sprintf("%s_%d", prefix, i),
+			Up:   fmt.Sprintf("CREATE TABLE %s_%d (id INTEGER PRIMARY KEY)", prefix, i),
+			Down: fmt.Sprintf("DROP TABLE %s_%d", prefix, i),
+		})
+	}
+	m, err := NewMigratorWithMigrations(db, adapter, migrations)
+	if err != nil {
+		t.Fatalf("Error making new migrator: %v", err)
+	}
+	m.Logger = nullLogger
+	return m
+}
+
 func TestNewMigratorFromMemory(t *testing.T) {
 	migrations := []*Migration{
 		{
@@ -68,6 +104,35 @@ func TestGetMigrationsFromPath(t *testing.T) {
 	}
 }
 
+func TestGetMigrationsFromFS(t *testing.T) {
+	m, err := MigrationsFromFS(testMigrations, "test_migrations/test1_pg", nullLogger)
+	if err != nil {
+		t.Fatalf("Error getting migrations: %v", err)
+	}
+	if len(m) != 4 {
+		t.Fatalf("Expected 4 migrations, got %d", len(m))
+	}
+	for _, migration := range m {
+		if migration.Up == "" || migration.Down == "" {
+			t.Errorf("Migration %d is missing its up or down sql", migration.ID)
+		}
+	}
+}
+
+func TestNewMigratorFromFS(t *testing.T) {
+	m, err := NewMigratorFromFS(db, adapter, testMigrations, "test_migrations/test1_"+dbType, nullLogger)
+	if err != nil {
+		t.Fatalf("Error making new migrator: %v", err)
+	}
+	migration := m.migrations[1]
+	if migration == nil || migration.Name != "test" {
+		t.Fatalf("Invalid migration loaded: %+v", migration)
+	}
+	if migration.Source != "test_migrations/test1_"+dbType+"/1_test_down.sql test_migrations/test1_"+dbType+"/1_test_up.sql" {
+		t.Errorf("Invalid migration source: %s", migration.Source)
+	}
+}
+
 func TestNewMigrator(t *testing.T) {
 	m := GetMigrator("test1")
 	switch {
@@ -103,7 +168,7 @@ func TestApplyMigrations(t *testing.T) {
 	m := &Migrator{
 		Logger: nullLogger,
 	}
-	err := m.ApplyMigration(&Migration{}, migrationType("foo"))
+	err := m.ApplyMigration(&Migration{}, MigrationType("foo"))
 	if err == nil {
 		t.Fatalf("Expected error on invalid migration type")
 	}
@@ -111,11 +176,11 @@ func TestApplyMigrations(t *testing.T) {
 
 func TestCreatingMigratorWhenTableExists(t *testing.T) {
 	// Create the table and populate it with a row.
-	_, err := db.Exec(adapter.CreateMigrationTableSql())
+	_, err := db.Exec(adapter.CreateMigrationTableSql(testTable))
 	if err != nil {
 		t.Error(err)
 	}
-	_, err = db.Exec(adapter.MigrationLogInsertSql(), 123)
+	_, err = db.Exec(adapter.MigrationLogInsertSql(testTable), 123, "existing", time.Now().UTC(), 0, "", "")
 	if err != nil {
 		t.Error(err)
 	}
@@ -146,6 +211,7 @@ func TestMigrationAndRollback(t *testing.T) {
 	row := db.QueryRow(
 		adapter.SelectMigrationTableSql(),
 		"test",
+		"",
 	)
 	var tableName string
 	if err := row.Scan(&tableName); err != nil {
@@ -156,7 +222,7 @@ func TestMigrationAndRollback(t *testing.T) {
 	}
 	// Ensure that the migrate status is correct.
 	row = db.QueryRow(
-		adapter.GetMigrationSql(),
+		adapter.GetMigrationSql(testTable),
 		1,
 	)
 	var status int
@@ -174,6 +240,7 @@ func TestMigrationAndRollback(t *testing.T) {
 	row = db.QueryRow(
 		adapter.SelectMigrationTableSql(),
 		"test",
+		"",
 	)
 	err := row.Scan(&tableName)
 	if err != nil && err != sql.ErrNoRows {
@@ -182,7 +249,7 @@ func TestMigrationAndRollback(t *testing.T) {
 
 	// Ensure that the migration log is missing.
 	row = db.QueryRow(
-		adapter.GetMigrationSql(),
+		adapter.GetMigrationSql(testTable),
 		1,
 	)
 	if err := row.Scan(&status); err != nil && err != sql.ErrNoRows {
@@ -195,6 +262,1216 @@ func TestMigrationAndRollback(t *testing.T) {
 	cleanup()
 }
 
+func TestMigrateWithCancelledContext(t *testing.T) {
+	m := GetMigrator("test1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.MigrateContext(ctx); err == nil {
+		t.Fatalf("Expected error migrating with a cancelled context")
+	}
+	for _, migration := range m.Migrations(-1) {
+		if migration.Status != Inactive {
+			t.Errorf("Migration %d applied with a cancelled context", migration.ID)
+		}
+	}
+}
+
+func TestMigrateTo(t *testing.T) {
+	m := newTableMigrator(t, "migrate_to", 3)
+
+	steps := []struct {
+		target uint64
+		active []uint64
+	}{
+		{2, []uint64{1, 2}},
+		{3, []uint64{1, 2, 3}},
+		{1, []uint64{1}},
+		{0, nil},
+	}
+	for _, step := range steps {
+		if err := m.MigrateTo(step.target); err != nil {
+			t.Fatalf("Error migrating to %d: %v", step.target, err)
+		}
+		var active []uint64
+		for _, migration := range m.Migrations(Active) {
+			active = append(active, migration.ID)
+		}
+		if fmt.Sprint(active) != fmt.Sprint(step.active) {
+			t.Errorf("Migrating to %d, expected active %v, got %v", step.target, step.active, active)
+		}
+	}
+
+	if err := m.MigrateTo(4); !errors.Is(err, ErrMigrationNotFound) {
+		t.Errorf("Expected ErrMigrationNotFound, got %v", err)
+	}
+
+	cleanup()
+}
+
+func TestTableLockInsertError(t *testing.T) {
+	if dbType != "sqlite3" {
+		t.Skip("Only sqlite3 locks with a table")
+	}
+	// Make inserting the lock row fail for a reason other than the lock
+	// being held.
+	for _, stmt := range []string{
+		"CREATE TABLE IF NOT EXISTS " + lockTableName + " (name TEXT PRIMARY KEY, locked_at TIMESTAMP NOT NULL)",
+		"CREATE TRIGGER lock_read_only BEFORE INSERT ON " + lockTableName + " BEGIN SELECT RAISE(ABORT, 'read only'); END",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer db.Exec("DROP TRIGGER lock_read_only")
+
+	start := time.Now()
+	_, err := Sqlite3{}.Lock(context.Background(), db, migrationTableName, time.Minute)
+	if err == nil || errors.Is(err, ErrLockTimeout) || !strings.Contains(err.Error(), "read only") {
+		t.Errorf("Expected the insert error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Expected the insert error to be returned without waiting, took %v", elapsed)
+	}
+}
+
+func TestMigrateWaitsForLock(t *testing.T) {
+	locker, ok := adapter.(Locker)
+	if !ok {
+		t.Skip("Adapter doesn't support locking")
+	}
+	unlock, err := locker.Lock(context.Background(), db, migrationTableName, time.Second)
+	if err != nil {
+		t.Fatalf("Error acquiring lock: %v", err)
+	}
+
+	m := GetMigrator("test1")
+	m.LockTimeout = 50 * time.Millisecond
+	err = m.Migrate()
+	if !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("Expected ErrLockTimeout, got %v", err)
+	}
+	if dbType == "sqlite3" && !strings.Contains(fmt.Sprint(err), lockTableName) {
+		t.Errorf("Expected the error to name the lock table, got %v", err)
+	}
+
+	if err := unlock(); err != nil {
+		t.Fatalf("Error releasing lock: %v", err)
+	}
+	if err := m.Migrate(); err != nil {
+		t.Errorf("Error migrating after lock was released: %v", err)
+	}
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+
+	cleanup()
+}
+
+func TestPlan(t *testing.T) {
+	m := GetMigrator("test1")
+
+	plan, err := m.Plan()
+	if err != nil {
+		t.Fatalf("Error planning migrations: %v", err)
+	}
+	if len(plan) != len(m.migrations) {
+		t.Fatalf("Expected %d planned migrations, got %d", len(m.migrations), len(plan))
+	}
+	if plan[0].Migration.ID != 1 || plan[0].Direction != UpMigration || len(plan[0].Commands) == 0 {
+		t.Errorf("Invalid plan for first migration: %+v", plan[0])
+	}
+	if exists, _ := m.MigrationTableExists(); exists {
+		t.Errorf("Planning shouldn't create the migrations table")
+	}
+
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	plan, err = m.Plan()
+	if err != nil {
+		t.Fatalf("Error planning migrations: %v", err)
+	}
+	if len(plan) != 0 {
+		t.Errorf("Expected nothing planned after migrating, got %d", len(plan))
+	}
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+
+	cleanup()
+
+	// Verifying checksums against a legacy migration table leaves it as it
+	// is.
+	createLegacyMigrationTable(t, 1)
+	m.VerifyChecksums = true
+	if _, err := m.Plan(); err != nil {
+		t.Fatalf("Error planning migrations: %v", err)
+	}
+	columns, err := m.migrationTableColumnSet(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columns["checksum"] {
+		t.Errorf("Planning shouldn't upgrade the migrations table, got columns %v", columns)
+	}
+
+	cleanup()
+}
+
+func TestMigrationLogColumns(t *testing.T) {
+	m := GetMigrator("test1")
+	m.AppliedBy = "tester"
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	row := db.QueryRow("select name, checksum, applied_by from gomigrate where migration_id = 1")
+	var name, checksum, appliedBy string
+	if err := row.Scan(&name, &checksum, &appliedBy); err != nil {
+		t.Fatal(err)
+	}
+	if name != "test" || checksum != m.migrations[1].Checksum() || appliedBy != "tester" {
+		t.Errorf("Invalid migration log: name %q, checksum %q, applied by %q", name, checksum, appliedBy)
+	}
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+
+	cleanup()
+}
+
+// Creates a migration table with the original id/migration_id schema,
+// recording the given migrations as applied.
+func createLegacyMigrationTable(t *testing.T, ids ...uint64) {
+	legacy := map[string]string{
+		"pg":      "CREATE TABLE gomigrate (id SERIAL PRIMARY KEY, migration_id BIGINT UNIQUE NOT NULL)",
+		"mysql":   "CREATE TABLE gomigrate (id INT NOT NULL AUTO_INCREMENT, migration_id BIGINT NOT NULL UNIQUE, PRIMARY KEY (id))",
+		"sqlite3": "CREATE TABLE gomigrate (id INTEGER PRIMARY KEY, migration_id INTEGER NOT NULL UNIQUE)",
+		"mssql":   "CREATE TABLE gomigrate (id INT NOT NULL IDENTITY, migration_id BIGINT NOT NULL UNIQUE, PRIMARY KEY (id))",
+	}
+	if _, err := db.Exec(legacy[dbType]); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if _, err := db.Exec(fmt.Sprintf("INSERT INTO gomigrate (migration_id) VALUES (%d)", id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUpgradeLegacyMigrationTable(t *testing.T) {
+	createLegacyMigrationTable(t, 1)
+
+	m, err := NewMigratorWithMigrations(db, adapter, []*Migration{
+		{ID: 1, Name: "legacy", Up: "CREATE TABLE legacy_1 (id INTEGER PRIMARY KEY)", Down: "DROP TABLE legacy_1"},
+		{ID: 2, Name: "upgraded", Up: "CREATE TABLE legacy_2 (id INTEGER PRIMARY KEY)", Down: "DROP TABLE legacy_2"},
+	})
+	if err != nil {
+		t.Fatalf("Error making new migrator: %v", err)
+	}
+	m.Logger = nullLogger
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	names := map[uint64]string{}
+	rows, err := db.Query("select migration_id, name from gomigrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var id uint64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			t.Fatal(err)
+		}
+		names[id] = name
+	}
+	rows.Close()
+	if len(names) != 2 || names[1] != "" || names[2] != "upgraded" {
+		t.Errorf("Invalid migration log after upgrade: %v", names)
+	}
+	if err := m.RollbackN(1); err != nil {
+		t.Error(err)
+	}
+
+	cleanup()
+}
+
+func TestVerifyLegacyMigrationTable(t *testing.T) {
+	createLegacyMigrationTable(t, 1)
+	m := GetMigrator("test1")
+
+	mismatches, err := m.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 0 {
+		t.Errorf("Expected migrations applied without checksums to be skipped, got %v", mismatches)
+	}
+	columns, err := m.migrationTableColumnSet(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columns["checksum"] {
+		t.Errorf("Expected Verify to leave a legacy migration table as it is, got columns %v", columns)
+	}
+
+	cleanup()
+}
+
+func TestVerify(t *testing.T) {
+	m := GetMigrator("test1")
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	mismatches, err := m.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 0 {
+		t.Errorf("Expected no checksum mismatches, got %d", len(mismatches))
+	}
+
+	original := m.migrations[1].Up
+	m.migrations[1].Up = original + "\n-- edited after it was applied"
+	mismatches, err = m.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 1 || mismatches[0].Migration.ID != 1 {
+		t.Fatalf("Expected a checksum mismatch for migration 1, got %v", mismatches)
+	}
+	if mismatches[0].Current == mismatches[0].Applied {
+		t.Errorf("Expected different checksums, got %s", mismatches[0].Current)
+	}
+
+	m.VerifyChecksums = true
+	if err := m.Migrate(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+	}
+	if _, err := m.Plan(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Expected Plan to fail with ErrChecksumMismatch, got %v", err)
+	}
+
+	m.migrations[1].Up = original
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+
+	cleanup()
+}
+
+func TestStatus(t *testing.T) {
+	m := newTableMigrator(t, "status", 4)
+
+	if err := m.CreateMigrationsTable(); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []uint64{1, 3} {
+		if err := m.ApplyMigration(m.migrations[id], UpMigration); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec(adapter.MigrationLogInsertSql(testTable), 99, "deleted_branch", time.Now().UTC(), 0, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		id    uint64
+		name  string
+		state MigrationState
+	}{
+		{1, "status_1", Applied},
+		{2, "status_2", Missing},
+		{3, "status_3", Applied},
+		{4, "status_4", Pending},
+		{99, "deleted_branch", Orphaned},
+	}
+	if len(report) != len(expected) {
+		t.Fatalf("Expected %d statuses, got %d", len(expected), len(report))
+	}
+	for i, e := range expected {
+		status := report[i]
+		if status.ID != e.id || status.Name != e.name || status.State != e.state {
+			t.Errorf("Expected status %v, got %+v", e, status)
+		}
+		if applied := !status.AppliedAt.IsZero(); applied != (e.state == Applied || e.state == Orphaned) {
+			t.Errorf("Invalid applied at for migration %d: %v", status.ID, status.AppliedAt)
+		}
+	}
+
+	for _, id := range []uint64{3, 1} {
+		if err := m.ApplyMigration(m.migrations[id], DownMigration); err != nil {
+			t.Error(err)
+		}
+	}
+
+	cleanup()
+
+	// Tables from older versions are read without being upgraded, and
+	// their rows have no applied at time.
+	createLegacyMigrationTable(t, 1)
+	report, err = m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 4 || report[0].State != Applied || !report[0].AppliedAt.IsZero() || report[1].State != Pending {
+		t.Errorf("Unexpected statuses for a legacy migration table: %+v", report)
+	}
+	columns, err := m.migrationTableColumnSet(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columns["name"] {
+		t.Errorf("Expected Status to leave a legacy migration table as it is, got columns %v", columns)
+	}
+
+	cleanup()
+}
+
+func TestOutOfOrderMigrations(t *testing.T) {
+	var migrations []*Migration
+	for i, id := range []uint64{20260101120000, 20260102120000, 20260103120000} {
+		migrations = append(migrations, &Migration{
+			ID:   id,
+			Name: fmt.Sprintf("branch_%d", i),
+			Up:   fmt.Sprintf("CREATE TABLE branch_%d (id INTEGER PRIMARY KEY)", i),
+			Down: fmt.Sprintf("DROP TABLE branch_%d", i),
+		})
+	}
+	m, err := NewMigratorWithMigrations(db, adapter, migrations)
+	if err != nil {
+		t.Fatalf("Error making new migrator: %v", err)
+	}
+	m.Logger = nullLogger
+
+	if err := m.CreateMigrationsTable(); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []uint64{20260101120000, 20260103120000} {
+		if err := m.ApplyMigration(m.migrations[id], UpMigration); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = m.Migrate()
+	if !errors.Is(err, ErrOutOfOrder) {
+		t.Fatalf("Expected ErrOutOfOrder, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "20260102120000") {
+		t.Errorf("Expected the error to list the out of order migration: %v", err)
+	}
+	if m.migrations[20260102120000].Status != Inactive {
+		t.Error("Expected the out of order migration not to be applied")
+	}
+	if _, err := m.Plan(); !errors.Is(err, ErrOutOfOrder) {
+		t.Errorf("Expected Plan to fail with ErrOutOfOrder, got %v", err)
+	}
+
+	// Migrating to an id below the newest applied one rolls it back first.
+	if err := m.MigrateTo(20260102120000); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.MigrateTo(20260101120000); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ApplyMigration(m.migrations[20260103120000], UpMigration); err != nil {
+		t.Fatal(err)
+	}
+
+	m.AllowOutOfOrder = true
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, migration := range m.Migrations(-1) {
+		if migration.Status != Active {
+			t.Errorf("Expected migration %d to be applied", migration.ID)
+		}
+	}
+
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+
+	cleanup()
+}
+
+// Records the hooks called, in order.
+type recordingHooks struct {
+	NoopHooks
+	events []string
+}
+
+func (h *recordingHooks) BeforeMigrate(ctx context.Context) error {
+	h.events = append(h.events, "before migrate")
+	return nil
+}
+
+func (h *recordingHooks) AfterMigrate(ctx context.Context) {
+	h.events = append(h.events, "after migrate")
+}
+
+func (h *recordingHooks) BeforeEach(ctx context.Context, migration *Migration, direction MigrationType) error {
+	h.events = append(h.events, fmt.Sprintf("before %d %s", migration.ID, direction))
+	return nil
+}
+
+func (h *recordingHooks) AfterEach(ctx context.Context, migration *Migration, direction MigrationType, elapsed time.Duration, err error) {
+	h.events = append(h.events, fmt.Sprintf("after %d %s: %v", migration.ID, direction, err))
+}
+
+func (h *recordingHooks) OnError(ctx context.Context, err error) {
+	h.events = append(h.events, fmt.Sprintf("error: %v", err))
+}
+
+// Refuses every migration.
+type refusingHooks struct {
+	NoopHooks
+	err error
+}
+
+func (h refusingHooks) BeforeEach(ctx context.Context, migration *Migration, direction MigrationType) error {
+	return h.err
+}
+
+func TestHooks(t *testing.T) {
+	failing := errors.New("failed")
+	migrations := []*Migration{
+		{
+			ID:   1,
+			Name: "hooks_1",
+			Up:   "CREATE TABLE hooks_1 (id INTEGER PRIMARY KEY)",
+			Down: "DROP TABLE hooks_1",
+		},
+		{
+			ID:   2,
+			Name: "hooks_2",
+			UpFunc: func(ctx context.Context, tx *sql.Tx) error {
+				return failing
+			},
+			DownFunc: func(ctx context.Context, tx *sql.Tx) error {
+				return nil
+			},
+		},
+	}
+	m, err := NewMigratorWithMigrations(db, adapter, migrations)
+	if err != nil {
+		t.Fatalf("Error making new migrator: %v", err)
+	}
+	m.Logger = nullLogger
+	hooks := &recordingHooks{}
+	m.Hooks = hooks
+
+	if err := m.Migrate(); !errors.Is(err, failing) {
+		t.Fatalf("Expected the migration function's error, got %v", err)
+	}
+	if err := m.MigrateTo(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RollbackAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.MigrateTo(99); !errors.Is(err, ErrMigrationNotFound) {
+		t.Fatalf("Expected ErrMigrationNotFound, got %v", err)
+	}
+	expected := []string{
+		"before migrate",
+		"before 1 up",
+		"after 1 up: <nil>",
+		"before 2 up",
+		"after 2 up: id: 2, name: hooks_2, direction: up, err: failed",
+		"error: id: 2, name: hooks_2, direction: up, err: failed",
+		"before migrate",
+		"after migrate",
+		"before migrate",
+		"before 1 down",
+		"after 1 down: <nil>",
+		"after migrate",
+		"before migrate",
+		"error: id: 99, err: Migration not found",
+	}
+	if !reflect.DeepEqual(hooks.events, expected) {
+		t.Errorf("Expected hooks %q, got %q", expected, hooks.events)
+	}
+
+	cleanup()
+}
+
+func TestMigrationError(t *testing.T) {
+	migrations := []*Migration{
+		{
+			ID:   1,
+			Name: "broken",
+			Up:   "CREATE TABLE broken_1 (id INTEGER PRIMARY KEY);\n\nNOT VALID SQL;\n",
+			Down: "DROP TABLE broken_1",
+		},
+	}
+	m, err := NewMigratorWithMigrations(db, adapter, migrations)
+	if err != nil {
+		t.Fatalf("Error making new migrator: %v", err)
+	}
+	m.Logger = nullLogger
+
+	err = m.Migrate()
+	var migrationErr *MigrationError
+	if !errors.As(err, &migrationErr) {
+		t.Fatalf("Expected a MigrationError, got %v", err)
+	}
+	if migrationErr.ID != 1 || migrationErr.Name != "broken" || migrationErr.Direction != UpMigration {
+		t.Errorf("Unexpected migration in error: %+v", migrationErr)
+	}
+	if migrationErr.StatementIndex != 1 || migrationErr.Statement != "NOT VALID SQL" || migrationErr.Line != 3 {
+		t.Errorf("Unexpected statement in error: %+v", migrationErr)
+	}
+	if migrationErr.Err == nil || migrationErr.RollbackErr != nil {
+		t.Errorf("Expected only the statement's error: %+v", migrationErr)
+	}
+	if m.migrations[1].Status != Inactive {
+		t.Error("Expected the failed migration not to be applied")
+	}
+
+	cleanup()
+}
+
+func TestSingleTransaction(t *testing.T) {
+	migrations := []*Migration{
+		{
+			ID:   1,
+			Name: "single_1",
+			Up:   "CREATE TABLE single_1 (id INTEGER PRIMARY KEY)",
+			Down: "DROP TABLE single_1",
+		},
+		{
+			ID:   2,
+			Name: "single_2",
+			Up:   "NOT VALID SQL",
+			Down: "SELECT 1",
+		},
+	}
+	m, err := NewMigratorWithMigrations(db, adapter, migrations)
+	if err != nil {
+		t.Fatalf("Error making new migrator: %v", err)
+	}
+	m.Logger = nullLogger
+	m.SingleTransaction = true
+	hooks := &recordingHooks{}
+	m.Hooks = hooks
+
+	var migrationErr *MigrationError
+	if err := m.Migrate(); !errors.As(err, &migrationErr) || migrationErr.ID != 2 {
+		t.Fatalf("Expected migration 2 to fail, got %v", err)
+	}
+	// Migration 1 was rolled back with migration 2, so its hook reports
+	// the failure.
+	if len(hooks.events) < 4 || !strings.HasPrefix(hooks.events[3], "after 1 up: id: 2") {
+		t.Errorf("Expected migration 1 to report the failure of migration 2, got %q", hooks.events)
+	}
+	m.Hooks = nil
+	if m.migrations[1].Status != Inactive {
+		t.Error("Expected migration 1 not to be applied")
+	}
+	var id uint64
+	if err := db.QueryRow(adapter.GetMigrationSql(testTable), 1).Scan(&id); err != sql.ErrNoRows {
+		t.Errorf("Expected migration 1 not to be recorded, got %v", err)
+	}
+	if _, err := db.Exec("SELECT * FROM single_1"); err == nil {
+		t.Error("Expected single_1 to be rolled back")
+	}
+
+	m.migrations[2].NoTransaction = true
+	if err := m.Migrate(); !errors.Is(err, ErrNoTransactionMigration) {
+		t.Errorf("Expected ErrNoTransactionMigration, got %v", err)
+	}
+
+	m.migrations[2].NoTransaction = false
+	m.migrations[2].Up = "CREATE TABLE single_2 (id INTEGER PRIMARY KEY)"
+	m.migrations[2].Down = "DROP TABLE single_2"
+	refused := errors.New("refused")
+	m.Hooks = refusingHooks{err: refused}
+	if err := m.Migrate(); err != refused {
+		t.Errorf("Expected the BeforeEach error as it is, got %v", err)
+	}
+	m.Hooks = nil
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, migration := range m.Migrations(-1) {
+		if migration.Status != Active {
+			t.Errorf("Expected migration %d to be applied", migration.ID)
+		}
+	}
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+
+	cleanup()
+}
+
+func TestTemplates(t *testing.T) {
+	t.Setenv("GOMIGRATE_TEST_SUFFIX", "users")
+	migrations := []*Migration{
+		{
+			ID:   1,
+			Name: "templated",
+			Up:   `CREATE TABLE {{.Prefix}}_{{env "GOMIGRATE_TEST_SUFFIX"}} (id INTEGER PRIMARY KEY)`,
+			Down: `DROP TABLE {{.Prefix}}_{{env "GOMIGRATE_TEST_SUFFIX"}}`,
+		},
+	}
+	m, err := NewMigratorWithMigrations(db, adapter, migrations)
+	if err != nil {
+		t.Fatalf("Error making new migrator: %v", err)
+	}
+	m.Logger = nullLogger
+	m.TemplateData = map[string]string{"Prefix": "tmpl"}
+	m.StrictTemplates = true
+
+	plan, err := m.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"CREATE TABLE tmpl_users (id INTEGER PRIMARY KEY)"}; len(plan) != 1 || !reflect.DeepEqual(plan[0].Commands, expected) {
+		t.Fatalf("Expected %q to be planned, got %+v", expected, plan)
+	}
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("SELECT * FROM tmpl_users"); err != nil {
+		t.Errorf("Expected the rendered table to exist: %v", err)
+	}
+	if mismatches, err := m.Verify(); err != nil || len(mismatches) != 0 {
+		t.Errorf("Expected the rendered checksum to be recorded, got %v, %v", mismatches, err)
+	}
+	m.TemplateData["Prefix"] = "other"
+	if mismatches, err := m.Verify(); err != nil || len(mismatches) != 1 {
+		t.Errorf("Expected different template data to change the checksum, got %v, %v", mismatches, err)
+	}
+	m.TemplateData["Prefix"] = "tmpl"
+	if err := m.RollbackAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	delete(m.TemplateData, "Prefix")
+	if err := m.Migrate(); err == nil {
+		t.Error("Expected a missing key to fail in strict mode")
+	}
+	m.TemplateData["Prefix"] = "tmpl"
+	os.Unsetenv("GOMIGRATE_TEST_SUFFIX")
+	if _, err := m.Plan(); err == nil {
+		t.Error("Expected an unset environment variable to fail in strict mode")
+	}
+	m.StrictTemplates = false
+	if plan, err := m.Plan(); err != nil || plan[0].Commands[0] != "CREATE TABLE tmpl_ (id INTEGER PRIMARY KEY)" {
+		t.Errorf("Expected an unset environment variable to render empty, got %+v, %v", plan, err)
+	}
+
+	cleanup()
+}
+
+func TestRepeatableMigrations(t *testing.T) {
+	migrations := []*Migration{
+		{
+			ID:   1,
+			Name: "rep_items",
+			Up:   "CREATE TABLE rep_items (id INTEGER PRIMARY KEY, name VARCHAR(255))",
+			Down: "DROP TABLE rep_items",
+		},
+		{
+			Name:       "rep_items_view",
+			Up:         "DROP VIEW IF EXISTS rep_items_view;\nCREATE VIEW rep_items_view AS SELECT id FROM rep_items",
+			Repeatable: true,
+		},
+	}
+	m, err := NewMigratorWithMigrations(db, adapter, migrations)
+	if err != nil {
+		t.Fatalf("Error making new migrator: %v", err)
+	}
+	m.Logger = nullLogger
+	hooks := &recordingHooks{}
+	m.Hooks = hooks
+
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("SELECT id FROM rep_items_view"); err != nil {
+		t.Errorf("Expected the view to exist: %v", err)
+	}
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if plan, err := m.Plan(); err != nil || len(plan) != 0 {
+		t.Errorf("Expected nothing to be planned, got %v, %v", plan, err)
+	}
+
+	repeatable := m.RepeatableMigrations()[0]
+	repeatable.Up = "DROP VIEW IF EXISTS rep_items_view;\nCREATE VIEW rep_items_view AS SELECT id, name FROM rep_items"
+	plan, err := m.Plan()
+	if err != nil || len(plan) != 1 || plan[0].Migration != repeatable {
+		t.Errorf("Expected the changed repeatable migration to be planned, got %v, %v", plan, err)
+	}
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("SELECT name FROM rep_items_view"); err != nil {
+		t.Errorf("Expected the view to be redefined: %v", err)
+	}
+
+	var applied []string
+	for _, event := range hooks.events {
+		if strings.HasPrefix(event, "before 0") {
+			applied = append(applied, event)
+		}
+	}
+	if len(applied) != 2 {
+		t.Errorf("Expected the repeatable migration to run twice, got %q", hooks.events)
+	}
+
+	for _, statement := range []string{"DROP VIEW rep_items_view", "DROP TABLE rep_items", "DROP TABLE " + m.repeatableTable()} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Error(err)
+		}
+	}
+	cleanup()
+}
+
+func TestRepeatableMigrationsFromFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/1_items_up.sql":   {Data: []byte("CREATE TABLE items (id INTEGER)")},
+		"migrations/1_items_down.sql": {Data: []byte("DROP TABLE items")},
+		"migrations/R_items_view.sql": {Data: []byte("CREATE VIEW items_view AS SELECT id FROM items")},
+	}
+	migrations, err := MigrationsFromFS(fsys, "migrations", nullLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewMigratorWithMigrations(db, adapter, migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Migrations(-1)) != 1 {
+		t.Errorf("Expected one versioned migration, got %d", len(m.Migrations(-1)))
+	}
+	repeatables := m.RepeatableMigrations()
+	if len(repeatables) != 1 || repeatables[0].Name != "items_view" || !repeatables[0].Repeatable {
+		t.Errorf("Expected the items_view repeatable migration, got %+v", repeatables)
+	}
+
+	duplicate := &Migration{Name: "items_view", Up: "SELECT 1", Repeatable: true}
+	if _, err := NewMigratorWithMigrations(db, adapter, append(migrations, duplicate)); !errors.Is(err, ErrDuplicateMigration) {
+		t.Errorf("Expected ErrDuplicateMigration, got %v", err)
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	m := newTableMigrator(t, "baseline", 4)
+	if err := m.Baseline(2, "legacy schema"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("SELECT * FROM baseline_1"); err == nil {
+		t.Error("Expected baselined migrations not to run")
+	}
+	if err := m.Baseline(2, "legacy schema"); !errors.Is(err, ErrAlreadyMigrated) {
+		t.Errorf("Expected ErrAlreadyMigrated, got %v", err)
+	}
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, migration := range m.Migrations(-1) {
+		if migration.Status != Active {
+			t.Errorf("Expected migration %d to be applied", migration.ID)
+		}
+	}
+	if _, err := db.Exec("SELECT * FROM baseline_3"); err != nil {
+		t.Errorf("Expected migrations after the baseline to run: %v", err)
+	}
+	if err := m.RollbackN(2); err != nil {
+		t.Error(err)
+	}
+	cleanup()
+
+	// Baselining at an id that isn't loaded adds a row for it.
+	m = newTableMigrator(t, "baseline", 4)
+	if err := m.Baseline(10, "v10"); err != nil {
+		t.Fatal(err)
+	}
+	report, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 5 || report[4].ID != 10 || report[4].Name != "v10" || report[4].State != Orphaned {
+		t.Errorf("Expected every migration applied and a row for 10, got %+v", report)
+	}
+	if err := m.Migrate(); err != nil {
+		t.Error(err)
+	}
+	if _, err := db.Exec("SELECT * FROM baseline_4"); err == nil {
+		t.Error("Expected baselined migrations not to run")
+	}
+	cleanup()
+
+	if err := m.Baseline(0, ""); err == nil {
+		t.Error("Expected an error baselining at id 0")
+	}
+}
+
+func TestGoFuncMigrations(t *testing.T) {
+	failing := errors.New("backfill failed")
+	migrations := []*Migration{
+		{
+			ID:   1,
+			Name: "create_func_test",
+			Up:   "CREATE TABLE func_test (id INTEGER PRIMARY KEY)",
+			UpFunc: func(ctx context.Context, tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, "INSERT INTO func_test (id) VALUES (1)")
+				return err
+			},
+			Down: "DROP TABLE func_test",
+		},
+		{
+			ID:   2,
+			Name: "failing_backfill",
+			UpFunc: func(ctx context.Context, tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, "INSERT INTO func_test (id) VALUES (2)"); err != nil {
+					return err
+				}
+				return failing
+			},
+		},
+	}
+	m, err := NewMigratorWithMigrations(db, adapter, migrations)
+	if err != nil {
+		t.Fatalf("Error making new migrator: %v", err)
+	}
+	m.Logger = nullLogger
+
+	if err := m.Migrate(); !errors.Is(err, failing) {
+		t.Fatalf("Expected the backfill error, got %v", err)
+	}
+	if m.migrations[1].Status != Active || m.migrations[2].Status != Inactive {
+		t.Errorf("Invalid migration statuses: %d, %d", m.migrations[1].Status, m.migrations[2].Status)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM func_test").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("Expected the failed backfill to be rolled back, found %d rows", count)
+	}
+
+	if err := m.ApplyMigration(m.migrations[1], DownMigration); err != nil {
+		t.Error(err)
+	}
+
+	cleanup()
+}
+
+func TestNoTransactionHeader(t *testing.T) {
+	cases := map[string]bool{
+		"-- gomigrate:no-transaction\nCREATE INDEX CONCURRENTLY i ON t (c);":                    true,
+		"-- adds an index\n--gomigrate:no-transaction\n\nCREATE INDEX CONCURRENTLY i ON t (c);": true,
+		"CREATE TABLE t (c INT);\n-- gomigrate:no-transaction":                                  false,
+		"CREATE TABLE t (c INT);": false,
+	}
+	for sql, expected := range cases {
+		if got := hasNoTransactionHeader(sql); got != expected {
+			t.Errorf("hasNoTransactionHeader(%q) = %v, expected %v", sql, got, expected)
+		}
+	}
+}
+
+func TestNoTransactionMigrations(t *testing.T) {
+	migrations := []*Migration{
+		{
+			ID:   1,
+			Name: "no_transaction",
+			Up:   "-- gomigrate:no-transaction\nCREATE TABLE notx_1 (id INTEGER PRIMARY KEY)",
+			Down: "DROP TABLE notx_1",
+		},
+		{
+			ID:            2,
+			Name:          "failing_no_transaction",
+			Up:            "INSERT INTO notx_missing (id) VALUES (1)",
+			NoTransaction: true,
+		},
+	}
+	m, err := NewMigratorWithMigrations(db, adapter, migrations)
+	if err != nil {
+		t.Fatalf("Error making new migrator: %v", err)
+	}
+	m.Logger = nullLogger
+
+	if err := m.Migrate(); err == nil {
+		t.Fatalf("Expected error applying failing migration")
+	}
+	if m.migrations[1].Status != Active || m.migrations[2].Status != Inactive {
+		t.Errorf("Invalid migration statuses: %d, %d", m.migrations[1].Status, m.migrations[2].Status)
+	}
+	var id uint64
+	if err := db.QueryRow(adapter.GetMigrationSql(testTable), 1).Scan(&id); err != nil {
+		t.Errorf("Expected migration 1 to be recorded: %v", err)
+	}
+	if err := db.QueryRow(adapter.GetMigrationSql(testTable), 2).Scan(&id); err != sql.ErrNoRows {
+		t.Errorf("Expected migration 2 not to be recorded, got %v", err)
+	}
+
+	if err := m.ApplyMigration(m.migrations[1], DownMigration); err != nil {
+		t.Error(err)
+	}
+
+	cleanup()
+}
+
+func TestRollbackMoreThanApplied(t *testing.T) {
+	m := GetMigrator("test1")
+	if err := m.MigrateTo(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RollbackN(5); err != nil {
+		t.Fatal(err)
+	}
+	if applied := m.Migrations(Active); len(applied) != 0 {
+		t.Errorf("Expected every migration to be rolled back, got %d applied", len(applied))
+	}
+
+	cleanup()
+}
+
+func TestRedo(t *testing.T) {
+	m := GetMigrator("test1")
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	hooks := &recordingHooks{}
+	m.Hooks = hooks
+	if err := m.Redo(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"before migrate",
+		"before 1 down", "after 1 down: <nil>",
+		"before 1 up", "after 1 up: <nil>",
+		"after migrate",
+	}
+	if !reflect.DeepEqual(hooks.events, expected) {
+		t.Errorf("Expected the last migration redone under one lock, got %q", hooks.events)
+	}
+	m.Hooks = nil
+	if applied := m.Migrations(Active); len(applied) != 1 {
+		t.Errorf("Expected the migration applied after redo, got %d applied", len(applied))
+	}
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+
+	cleanup()
+}
+
+func TestCreateMigrationsTableError(t *testing.T) {
+	m := GetMigrator("test1")
+	m.Schema = "no_such_schema"
+
+	err := m.CreateMigrationsTable()
+	if !errors.Is(err, ErrCreateMigrationTable) {
+		t.Fatalf("Expected ErrCreateMigrationTable, got %v", err)
+	}
+}
+
+func TestCustomMigrationTable(t *testing.T) {
+	schemas := map[string]string{
+		"pg":      "public",
+		"mysql":   "gomigrate",
+		"sqlite3": "main",
+		"mssql":   "dbo",
+	}
+	m := GetMigrator("test1")
+	m.TableName = "custom migrations"
+	m.Schema = schemas[dbType]
+
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := m.MigrationTableExists(); err != nil || !exists {
+		t.Errorf("Expected the custom migration table to exist: %v", err)
+	}
+	var id uint64
+	if err := db.QueryRow(adapter.GetMigrationSql(m.table()), 1).Scan(&id); err != nil {
+		t.Errorf("Expected migration to be recorded in the custom table: %v", err)
+	}
+	if err := db.QueryRow(adapter.SelectMigrationTableSql(), migrationTableName, "").Scan(new(string)); err != sql.ErrNoRows {
+		t.Errorf("Expected the default migration table not to exist, got %v", err)
+	}
+
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+	if _, err := db.Exec("DROP TABLE " + m.table()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCreateMigration(t *testing.T) {
+	dir := t.TempDir()
+
+	up, down, err := CreateMigration(dir, "add users!", SequentialIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(up) != "1_add_users_up.sql" || filepath.Base(down) != "1_add_users_down.sql" {
+		t.Errorf("Unexpected migration files: %s, %s", up, down)
+	}
+	if up, _, err = CreateMigration(dir, "add-posts", SequentialIDs); err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(up) != "2_add-posts_up.sql" {
+		t.Errorf("Expected the next sequential id, got %s", up)
+	}
+
+	if up, _, err = CreateMigration(dir, "add_tags", TimestampIDs); err != nil {
+		t.Fatal(err)
+	}
+	id, _, _, err := parseMigrationPath(up)
+	if err != nil || id < 20000101000000 {
+		t.Errorf("Expected a timestamp id, got %s", up)
+	}
+	if up, _, err = CreateMigration(dir, "add_likes", TimestampIDs); err != nil {
+		t.Fatal(err)
+	}
+	if next, _, _, _ := parseMigrationPath(up); next <= id {
+		t.Errorf("Expected ids to increase, got %d after %d", next, id)
+	}
+
+	migrations, err := MigrationsFromPath(dir, nullLogger)
+	if err != nil || len(migrations) != 4 {
+		t.Errorf("Expected the created migrations to load, got %d: %v", len(migrations), err)
+	}
+	if _, _, err := CreateMigration(dir, "?!", SequentialIDs); err == nil {
+		t.Error("Expected an error for an empty name")
+	}
+}
+
+// Records what's printed to it.
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Print(v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprint(v...))
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func (l *recordingLogger) Println(v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprint(v...))
+}
+
+func TestLoggerHandler(t *testing.T) {
+	logger := &recordingLogger{}
+	log := slog.New(NewLoggerHandler(logger)).With("migration_id", 1).WithGroup("db")
+	log.Info("Applied migration", "name", "add users", slog.Group("stats", "rows_affected", 2), "duration", 3*time.Millisecond)
+
+	expected := []string{`Applied migration migration_id=1 db.name="add users" db.stats.rows_affected=2 db.duration=3ms`}
+	if !reflect.DeepEqual(logger.lines, expected) {
+		t.Errorf("Expected %q, got %q", expected, logger.lines)
+	}
+}
+
+func TestStructuredLogging(t *testing.T) {
+	var buf bytes.Buffer
+	m := GetMigrator("test1")
+	m.SlogLogger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	if err := m.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	var applied, executed bool
+	decoder := json.NewDecoder(&buf)
+	for decoder.More() {
+		var record map[string]interface{}
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		switch record["msg"] {
+		case "Applied migration":
+			applied = true
+			for _, key := range []string{"migration_id", "name", "direction", "duration"} {
+				if _, ok := record[key]; !ok {
+					t.Errorf("Expected %s in %v", key, record)
+				}
+			}
+		case "Executed statement":
+			executed = true
+			for _, key := range []string{"migration_id", "statement", "rows_affected"} {
+				if _, ok := record[key]; !ok {
+					t.Errorf("Expected %s in %v", key, record)
+				}
+			}
+		}
+	}
+	if !applied || !executed {
+		t.Errorf("Expected applied and executed records, got applied: %v, executed: %v", applied, executed)
+	}
+
+	if err := m.RollbackAll(); err != nil {
+		t.Error(err)
+	}
+	cleanup()
+}
+
+// Returns a migrator with n migrations, all of them recorded as applied.
+func appliedMigrator(b *testing.B, n int) *Migrator {
+	m := newTableMigrator(b, "bench", n)
+	if err := m.CreateMigrationsTable(); err != nil {
+		b.Fatal(err)
+	}
+	for _, migration := range m.Migrations(-1) {
+		if _, err := db.Exec(adapter.MigrationLogInsertSql(testTable), migration.ID, migration.Name, time.Now().UTC(), 0, "", ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return m
+}
+
+// Looks up the status of each migration with its own query, as was done
+// before the applied ids were read at once.
+func BenchmarkMigrationStatusesPerRow(b *testing.B) {
+	m := appliedMigrator(b, 1500)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, migration := range m.migrations {
+			var id uint64
+			if err := db.QueryRowContext(ctx, adapter.GetMigrationSql(testTable), migration.ID).Scan(&id); err != nil {
+				b.Fatal(err)
+			}
+			migration.Status = Active
+		}
+	}
+	b.StopTimer()
+	cleanup()
+}
+
+func BenchmarkMigrationStatuses(b *testing.B) {
+	m := appliedMigrator(b, 1500)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := m.getMigrationStatuses(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	cleanup()
+}
+
 func cleanup() {
 	_, err := db.Exec("drop table gomigrate")
 	if err != nil {
@@ -231,4 +1508,5 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+	testTable = adapter.QuoteTable("", migrationTableName)
 }
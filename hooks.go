@@ -0,0 +1,78 @@
+// Lets callers observe migrations as they're applied.
+
+package gomigrate
+
+import (
+	"context"
+	"time"
+)
+
+// Hooks are called as migrations are applied, to emit metrics, post
+// notifications, take snapshots or refresh materialized views.  Embed
+// NoopHooks to implement only some of them.
+//
+// BeforeMigrate, AfterMigrate and OnError are called by Migrate, MigrateTo,
+// RollbackN and Redo, and the variants of them, while the migration lock is
+// held.
+// BeforeEach and AfterEach are called around every migration those apply, and
+// around ApplyMigration.
+type Hooks interface {
+	// BeforeMigrate is called before the migration table is read.
+	// Returning an error aborts without applying anything.
+	BeforeMigrate(ctx context.Context) error
+	// AfterMigrate is called once every migration has been applied
+	// successfully.
+	AfterMigrate(ctx context.Context)
+	// BeforeEach is called before a migration is applied in the given
+	// direction.  Returning an error aborts without applying it, and the
+	// error is returned as it is.
+	BeforeEach(ctx context.Context, migration *Migration, direction MigrationType) error
+	// AfterEach is called after a migration is applied, with the time it
+	// took and the error it failed with, if any.  With SingleTransaction
+	// it's called once the transaction is committed or rolled back, with
+	// the error that ended it, so migrations rolled back because a later
+	// one failed report that failure.
+	AfterEach(ctx context.Context, migration *Migration, direction MigrationType, elapsed time.Duration, err error)
+	// OnError is called with the error Migrate, MigrateTo, RollbackN or
+	// Redo fail with, including failures to take the migration lock.
+	OnError(ctx context.Context, err error)
+}
+
+// NoopHooks implements Hooks by doing nothing.
+type NoopHooks struct{}
+
+func (NoopHooks) BeforeMigrate(ctx context.Context) error { return nil }
+
+func (NoopHooks) AfterMigrate(ctx context.Context) {}
+
+func (NoopHooks) BeforeEach(ctx context.Context, migration *Migration, direction MigrationType) error {
+	return nil
+}
+
+func (NoopHooks) AfterEach(ctx context.Context, migration *Migration, direction MigrationType, elapsed time.Duration, err error) {
+}
+
+func (NoopHooks) OnError(ctx context.Context, err error) {}
+
+// Runs fn while holding the migration lock, calling the batch hooks around
+// it.
+func (m *Migrator) runLocked(ctx context.Context, fn func() error) error {
+	err := m.withLock(ctx, func() error {
+		if m.Hooks != nil {
+			if err := m.Hooks.BeforeMigrate(ctx); err != nil {
+				return err
+			}
+		}
+		if err := fn(); err != nil {
+			return err
+		}
+		if m.Hooks != nil {
+			m.Hooks.AfterMigrate(ctx)
+		}
+		return nil
+	})
+	if err != nil && m.Hooks != nil {
+		m.Hooks.OnError(ctx, err)
+	}
+	return err
+}
@@ -0,0 +1,239 @@
+// Serialises migrations across processes sharing a database.
+
+package gomigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+const (
+	// DefaultLockTimeout is how long a new Migrator waits for another
+	// process to finish migrating before giving up.
+	DefaultLockTimeout = 10 * time.Minute
+
+	lockTableName    = "gomigrate_lock"
+	lockPollInterval = 100 * time.Millisecond
+)
+
+var ErrLockTimeout = errors.New("Timed out waiting for migration lock")
+
+// Locker is implemented by adapters that can take a database wide lock, so
+// that several processes calling Migrate at once apply each migration only
+// one time.  Lock blocks until the named lock is held, the timeout elapses or
+// the context is done, and returns a function releasing the lock.  A timeout
+// of zero waits until the context is done.
+//
+// Some implementations hold a connection from the pool for as long as the
+// lock is held, so a *sql.DB limited to a single open connection can't be
+// used with them.
+type Locker interface {
+	Lock(ctx context.Context, db *sql.DB, name string, timeout time.Duration) (func() error, error)
+}
+
+// Runs fn while holding the migration lock, if the adapter supports one and
+// locking hasn't been disabled.
+func (m *Migrator) withLock(ctx context.Context, fn func() error) (err error) {
+	locker, ok := m.dbAdapter.(Locker)
+	if !ok || m.DisableLocking {
+		return fn()
+	}
+
+	// Migrators using different tables keep separate histories, so they
+	// don't need to wait for each other.
+	name := m.tableName()
+	if m.Schema != "" {
+		name = m.Schema + "." + name
+	}
+	m.log().Info("Acquiring migration lock", "lock", name)
+	unlock, err := locker.Lock(ctx, m.DB, name, m.LockTimeout)
+	if err != nil {
+		m.log().Error("Error acquiring migration lock", "lock", name, "error", err)
+		return err
+	}
+	defer func() {
+		if unlockErr := unlock(); unlockErr != nil {
+			m.log().Error("Error releasing migration lock", "lock", name, "error", unlockErr)
+			if err == nil {
+				err = unlockErr
+			}
+		}
+	}()
+
+	return fn()
+}
+
+// Returns a context bounded by the lock timeout, if there is one.
+func lockContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Translates a lock context expiring into ErrLockTimeout, leaving
+// cancellation of the caller's context alone.
+func lockError(ctx, lockCtx context.Context, name string, err error) error {
+	if ctx.Err() == nil && lockCtx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("lock: %s, err: %w", name, ErrLockTimeout)
+	}
+	return err
+}
+
+// Runs query on a dedicated connection until it returns true, for adapters
+// whose locks belong to a database session.  The connection is kept until
+// the lock is released.
+func sessionLock(ctx context.Context, db *sql.DB, name string, timeout time.Duration, lockSql, unlockSql string, args ...interface{}) (func() error, error) {
+	lockCtx, cancel := lockContext(ctx, timeout)
+	defer cancel()
+
+	conn, err := db.Conn(lockCtx)
+	if err != nil {
+		return nil, lockError(ctx, lockCtx, name, err)
+	}
+	for {
+		var acquired bool
+		err := conn.QueryRowContext(lockCtx, lockSql, args...).Scan(&acquired)
+		if err != nil {
+			conn.Close()
+			return nil, lockError(ctx, lockCtx, name, err)
+		}
+		if acquired {
+			break
+		}
+		select {
+		case <-lockCtx.Done():
+			conn.Close()
+			return nil, lockError(ctx, lockCtx, name, lockCtx.Err())
+		case <-time.After(lockPollInterval):
+		}
+	}
+
+	return func() error {
+		defer conn.Close()
+		_, err := conn.ExecContext(context.Background(), unlockSql, args...)
+		return err
+	}, nil
+}
+
+// Takes a lock by inserting a row in a lock table, for adapters without
+// session level locks.  A process dying while holding the lock leaves the row
+// behind, and it has to be deleted by hand.
+func tableLock(ctx context.Context, db *sql.DB, name string, timeout time.Duration, createSql, insertSql, selectSql, deleteSql string) (func() error, error) {
+	lockCtx, cancel := lockContext(ctx, timeout)
+	defer cancel()
+
+	if _, err := db.ExecContext(lockCtx, createSql); err != nil {
+		return nil, lockError(ctx, lockCtx, name, err)
+	}
+	retried := false
+	for {
+		_, err := db.ExecContext(lockCtx, insertSql, name, time.Now().UTC())
+		if err == nil {
+			break
+		}
+		// Drivers don't report unique violations in a common way, so the
+		// lock is only held if its row exists.  A missing row may have just
+		// been deleted by the holder, so the insert is retried once before
+		// its error is returned.
+		var held int
+		selectErr := db.QueryRowContext(lockCtx, selectSql, name).Scan(&held)
+		if errors.Is(selectErr, sql.ErrNoRows) {
+			if retried {
+				return nil, lockError(ctx, lockCtx, name, err)
+			}
+			retried = true
+			continue
+		}
+		if selectErr != nil {
+			return nil, lockError(ctx, lockCtx, name, selectErr)
+		}
+		retried = false
+		select {
+		case <-lockCtx.Done():
+			err = lockError(ctx, lockCtx, name, err)
+			if errors.Is(err, ErrLockTimeout) {
+				err = fmt.Errorf("%w, if no process is migrating, the lock was left by one that died: delete the row named %q from the %s table", err, name, lockTableName)
+			}
+			return nil, err
+		case <-time.After(lockPollInterval):
+		}
+	}
+
+	return func() error {
+		_, err := db.ExecContext(context.Background(), deleteSql, name)
+		return err
+	}, nil
+}
+
+// Hashes a lock name to a key for adapters that lock on integers.
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// POSTGRES
+
+func (p Postgres) Lock(ctx context.Context, db *sql.DB, name string, timeout time.Duration) (func() error, error) {
+	return sessionLock(ctx, db, name, timeout,
+		"SELECT pg_try_advisory_lock($1)",
+		"SELECT pg_advisory_unlock($1)",
+		lockKey(name),
+	)
+}
+
+// CockroachDB
+
+func (c CockroachDB) Lock(ctx context.Context, db *sql.DB, name string, timeout time.Duration) (func() error, error) {
+	return tableLock(ctx, db, name, timeout,
+		`CREATE TABLE IF NOT EXISTS `+lockTableName+` (
+                  name         VARCHAR(255) PRIMARY KEY,
+                  locked_at    TIMESTAMP    NOT NULL
+                )`,
+		"INSERT INTO "+lockTableName+" (name, locked_at) VALUES ($1, $2)",
+		"SELECT 1 FROM "+lockTableName+" WHERE name = $1",
+		"DELETE FROM "+lockTableName+" WHERE name = $1",
+	)
+}
+
+// MYSQL
+
+func (m Mysql) Lock(ctx context.Context, db *sql.DB, name string, timeout time.Duration) (func() error, error) {
+	// GET_LOCK names are server wide, so scope them to the current database.
+	return sessionLock(ctx, db, name, timeout,
+		"SELECT COALESCE(GET_LOCK(CONCAT(DATABASE(), '.', ?), 0), 0)",
+		"SELECT RELEASE_LOCK(CONCAT(DATABASE(), '.', ?))",
+		name,
+	)
+}
+
+// SQLITE3
+
+func (s Sqlite3) Lock(ctx context.Context, db *sql.DB, name string, timeout time.Duration) (func() error, error) {
+	return tableLock(ctx, db, name, timeout,
+		`CREATE TABLE IF NOT EXISTS `+lockTableName+` (
+  name TEXT PRIMARY KEY,
+  locked_at TIMESTAMP NOT NULL
+)`,
+		"INSERT INTO "+lockTableName+" (name, locked_at) VALUES (?, ?)",
+		"SELECT 1 FROM "+lockTableName+" WHERE name = ?",
+		"DELETE FROM "+lockTableName+" WHERE name = ?",
+	)
+}
+
+// MSSQL
+
+func (m Mssql) Lock(ctx context.Context, db *sql.DB, name string, timeout time.Duration) (func() error, error) {
+	return sessionLock(ctx, db, name, timeout,
+		`DECLARE @result INT;
+EXEC @result = sp_getapplock @Resource = ?, @LockMode = 'Exclusive', @LockOwner = 'Session', @LockTimeout = 0;
+SELECT CAST(CASE WHEN @result >= 0 THEN 1 ELSE 0 END AS BIT)`,
+		"EXEC sp_releaseapplock @Resource = ?, @LockOwner = 'Session'",
+		name,
+	)
+}
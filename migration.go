@@ -3,9 +3,15 @@
 package gomigrate
 
 import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"fmt"
-	"io/ioutil"
-	"path/filepath"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
 )
 
 // Migration statuses.
@@ -14,6 +20,10 @@ const (
 	Active
 )
 
+// MigrationFunc is a migration written in Go.  It runs in the migration's
+// transaction, and returning an error rolls the migration back.
+type MigrationFunc func(ctx context.Context, tx *sql.Tx) error
+
 // Migration holds configuration information for a given migration.
 type Migration struct {
 	ID     uint64
@@ -22,10 +32,27 @@ type Migration struct {
 	Up     string
 	Down   string
 	Source string
+	// UpFunc and DownFunc run after the Up or Down sql, if any, for
+	// migrations that need Go code.
+	UpFunc   MigrationFunc
+	DownFunc MigrationFunc
+	// NoTransaction runs the migration's statements outside a transaction,
+	// for statements such as CREATE INDEX CONCURRENTLY.  The same is done
+	// for sql starting with a "-- gomigrate:no-transaction" comment.
+	NoTransaction bool
+	// Repeatable migrations, such as views and functions that are
+	// redefined over time, have no id or down migration.  Migrate applies
+	// them after the other migrations, in name order, whenever their
+	// checksum differs from the one they were last applied with.  They're
+	// loaded from files named R_NAME.sql.
+	Repeatable bool
 }
 
 // Validate checks that a migration is properly formed and named.
 func (m *Migration) Validate() error {
+	if m.Repeatable {
+		return m.validateRepeatable()
+	}
 	if m.ID == 0 {
 		return &ErrInvalidMigration{
 			ID:   m.ID,
@@ -43,6 +70,50 @@ func (m *Migration) Validate() error {
 	return nil
 }
 
+// Checks that a repeatable migration is named and has something to apply.
+func (m *Migration) validateRepeatable() error {
+	if m.ID != 0 {
+		return &ErrInvalidMigration{
+			ID:   m.ID,
+			Name: m.Name,
+			Err:  "Repeatable migrations can't have an id",
+		}
+	}
+	if m.Name == "" {
+		return &ErrInvalidMigration{
+			ID:   m.ID,
+			Name: m.Name,
+			Err:  "Name can't be empty",
+		}
+	}
+	if m.Up == "" && m.UpFunc == nil {
+		return &ErrInvalidMigration{
+			ID:   m.ID,
+			Name: m.Name,
+			Err:  "Repeatable migrations need up sql or an UpFunc",
+		}
+	}
+	return nil
+}
+
+// Checksum returns the hex encoded SHA-256 checksum of the up migration, as
+// recorded in the migration table when it's applied.  Migrations without up
+// sql, such as those only having an UpFunc, have no checksum.  Migrators
+// rendering templates record the checksum of the rendered sql instead.
+func (m *Migration) Checksum() string {
+	return checksum(m.Up)
+}
+
+// Returns the hex encoded SHA-256 checksum of sql, or an empty string if
+// there is no sql.
+func checksum(sql string) string {
+	if sql == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(sql))
+	return hex.EncodeToString(sum[:])
+}
+
 // ErrInvalidMigration encapsulates the reasons why a migration is invalid.
 type ErrInvalidMigration struct {
 	ID   uint64
@@ -58,15 +129,77 @@ func (e *ErrInvalidMigration) Error() string {
 	return fmt.Sprintf("Invalid Migration ID:%d, Name:'%s': %s", e.ID, e.Name, e.Err)
 }
 
+// MigrationError describes a migration that failed to apply.  It wraps the
+// error the migration failed with and, if rolling back its transaction failed
+// too, the error doing so, so errors.Is and errors.As see through to both.
+type MigrationError struct {
+	ID        uint64
+	Name      string
+	Direction MigrationType
+	// StatementIndex is the index of the failed statement among the
+	// migration's, or -1 if something else failed, such as the migration
+	// function or recording the migration.
+	StatementIndex int
+	Statement      string
+	// Line is the line of the migration's sql the code of the failed
+	// statement starts on, after any comments leading it, counting from 1,
+	// or 0 if unknown.  With TemplateData set, it's the line of the
+	// rendered sql.
+	Line        int
+	Err         error
+	RollbackErr error
+}
+
+func newMigrationError(migration *Migration, mType MigrationType, step *migrationStep, index int, err error) *MigrationError {
+	migrationErr := &MigrationError{
+		ID:             migration.ID,
+		Name:           migration.Name,
+		Direction:      mType,
+		StatementIndex: -1,
+		Err:            err,
+	}
+	if step != nil && index >= 0 && index < len(step.commands) {
+		migrationErr.StatementIndex = index
+		migrationErr.Statement = step.commands[index]
+		migrationErr.Line = step.line(index)
+	}
+	return migrationErr
+}
+
+func (e *MigrationError) Error() string {
+	if e == nil {
+		return "nil"
+	}
+
+	msg := fmt.Sprintf("id: %d, name: %s, direction: %s", e.ID, e.Name, e.Direction)
+	if e.StatementIndex >= 0 {
+		msg += fmt.Sprintf(", statement: %d, line: %d", e.StatementIndex, e.Line)
+	}
+	msg += fmt.Sprintf(", err: %v", e.Err)
+	if e.RollbackErr != nil {
+		msg += fmt.Sprintf(", rollback err: %v", e.RollbackErr)
+	}
+	return msg
+}
+
+func (e *MigrationError) Unwrap() []error {
+	if e.RollbackErr == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Err, e.RollbackErr}
+}
+
 // MigrationsFromPath loads migrations from the given path.  Migration file
 // naming and format requires two files per migration of the form:
 // NUMBER_NAME_[UP|DOWN].sql
 //
 // Example:
-//  1_add_users_table_up.sql
-//  1_add_users_table_down.sql
 //
-// The name must match for each numbered pair.
+//	1_add_users_table_up.sql
+//	1_add_users_table_down.sql
+//
+// The name must match for each numbered pair.  Repeatable migrations are
+// single files of the form R_NAME.sql holding their up migration.
 func MigrationsFromPath(migrationsPath string, logger Logger) ([]*Migration, error) {
 	// Normalize the migrations path.
 	path := []byte(migrationsPath)
@@ -75,33 +208,72 @@ func MigrationsFromPath(migrationsPath string, logger Logger) ([]*Migration, err
 		path = append(path, '/')
 	}
 
-	logger.Printf("Migrations path: %s", path)
+	log := slog.New(NewLoggerHandler(logger))
+	log.Info("Loading migrations", "path", string(path))
+	return migrationsFromFS(os.DirFS(string(path)), ".", string(path), log)
+}
+
+// MigrationsFromFS loads migrations from the given directory of a file
+// system, such as an embed.FS.  Files are named as for MigrationsFromPath.
+//
+// Example:
+//
+//	//go:embed migrations
+//	var migrationFiles embed.FS
+//
+//	migrations, err := gomigrate.MigrationsFromFS(migrationFiles, "migrations", logger)
+func MigrationsFromFS(fsys fs.FS, dir string, logger Logger) ([]*Migration, error) {
+	log := slog.New(NewLoggerHandler(logger))
+	log.Info("Loading migrations", "dir", dir)
+	return migrationsFromFS(fsys, dir, "", log)
+}
+
+// Loads migrations from dir, recording the path of each file prefixed with
+// sourcePrefix as its source.
+func migrationsFromFS(fsys fs.FS, dir string, sourcePrefix string, logger *slog.Logger) ([]*Migration, error) {
 	migrations := map[uint64]*Migration{}
+	var repeatables []*Migration
 
-	pathGlob := append([]byte(path), []byte("*")...)
-	matches, err := filepath.Glob(string(pathGlob))
+	matches, err := fs.Glob(fsys, path.Join(dir, "*"))
 	if err != nil {
 		return nil, fmt.Errorf("Error while globbing migrations: %v", err)
 	}
 
 	for _, match := range matches {
+		source := sourcePrefix + match
+		if name, ok := parseRepeatablePath(match); ok {
+			logger.Debug("Repeatable migration file found", "source", source)
+			fileSQL, err := fs.ReadFile(fsys, match)
+			if err != nil {
+				logger.Error("Error reading migration", "source", source, "error", err)
+				return nil, err
+			}
+			repeatables = append(repeatables, &Migration{
+				Name:       name,
+				Up:         string(fileSQL),
+				Source:     source,
+				Status:     Inactive,
+				Repeatable: true,
+			})
+			continue
+		}
 		num, migrationType, name, err := parseMigrationPath(match)
 		if err != nil {
-			logger.Printf("Invalid migration file found: %s\n", match)
+			logger.Warn("Invalid migration file found", "source", source)
 			continue
 		}
 
-		logger.Printf("Migration file found: %s\n", match)
-		fileSQL, err := ioutil.ReadFile(match)
+		logger.Debug("Migration file found", "source", source)
+		fileSQL, err := fs.ReadFile(fsys, match)
 		if err != nil {
-			logger.Printf("Error reading migration: %s", match)
+			logger.Error("Error reading migration", "source", source, "error", err)
 			return nil, err
 		}
 		sql := string(fileSQL)
 
 		if m, ok := migrations[num]; ok {
-			m.Source = m.Source + " " + match
-			if migrationType == upMigration {
+			m.Source = m.Source + " " + source
+			if migrationType == UpMigration {
 				m.Up = sql
 			} else {
 				m.Down = sql
@@ -110,10 +282,10 @@ func MigrationsFromPath(migrationsPath string, logger Logger) ([]*Migration, err
 			migration := &Migration{
 				ID:     num,
 				Name:   name,
-				Source: match,
+				Source: source,
 				Status: Inactive,
 			}
-			if migrationType == upMigration {
+			if migrationType == UpMigration {
 				migration.Up = sql
 			} else {
 				migration.Down = sql
@@ -126,17 +298,18 @@ func MigrationsFromPath(migrationsPath string, logger Logger) ([]*Migration, err
 	for _, migration := range migrations {
 		err = migration.Validate()
 		if err != nil {
-			logger.Printf("Invalid migration from files: %s\n", migration.Source)
+			logger.Error("Invalid migration from files", "source", migration.Source, "error", err)
 			return nil, InvalidMigrationPair
 		}
 	}
 
-	logger.Printf("Migrations file pairs found: %v\n", len(migrations))
+	logger.Info("Migrations loaded", "count", len(migrations), "repeatable", len(repeatables))
 
-	v := make([]*Migration, 0, len(migrations))
+	v := make([]*Migration, 0, len(migrations)+len(repeatables))
 	for _, value := range migrations {
 		v = append(v, value)
 	}
+	v = append(v, repeatables...)
 
 	return v, nil
 }
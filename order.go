@@ -0,0 +1,50 @@
+// Detects pending migrations older than applied ones.
+
+package gomigrate
+
+import (
+	"fmt"
+)
+
+// Returns the pending migrations up to and including upTo that are older
+// than the newest applied migration that would remain applied, such as
+// those merged from a branch after newer migrations were applied.
+func (m *Migrator) outOfOrderMigrations(upTo uint64) ([]*Migration, uint64) {
+	var latestApplied uint64
+	for _, migration := range m.Migrations(Active) {
+		if migration.ID <= upTo {
+			latestApplied = migration.ID
+		}
+	}
+
+	var outOfOrder []*Migration
+	for _, migration := range m.Migrations(Inactive) {
+		if migration.ID >= latestApplied {
+			break
+		}
+		outOfOrder = append(outOfOrder, migration)
+	}
+	return outOfOrder, latestApplied
+}
+
+// Returns ErrOutOfOrder listing the pending migrations up to and including
+// upTo that are older than the newest applied one, unless they're allowed.
+func (m *Migrator) refuseOutOfOrder(upTo uint64) error {
+	outOfOrder, latestApplied := m.outOfOrderMigrations(upTo)
+	if len(outOfOrder) == 0 {
+		return nil
+	}
+	ids := make([]uint64, 0, len(outOfOrder))
+	for _, migration := range outOfOrder {
+		if m.AllowOutOfOrder {
+			m.migrationLogger(migration, UpMigration).Warn("Applying migration out of order", "newest_applied", latestApplied)
+			continue
+		}
+		m.migrationLogger(migration, UpMigration).Error("Migration is older than the newest applied one", "newest_applied", latestApplied)
+		ids = append(ids, migration.ID)
+	}
+	if m.AllowOutOfOrder {
+		return nil
+	}
+	return fmt.Errorf("ids: %v, newest applied: %d, err: %w", ids, latestApplied, ErrOutOfOrder)
+}
@@ -0,0 +1,172 @@
+// Applies repeatable migrations whenever they change.
+
+package gomigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// RepeatableMigrations returns the repeatable migrations, sorted by name.
+func (m *Migrator) RepeatableMigrations() []*Migration {
+	migrations := make([]*Migration, 0, len(m.repeatables))
+	for _, migration := range m.repeatables {
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(a, b int) bool {
+		return migrations[a].Name < migrations[b].Name
+	})
+	return migrations
+}
+
+// Returns the name of the table recording repeatable migrations.
+func (m *Migrator) repeatableTableName() string {
+	return m.tableName() + "_repeatable"
+}
+
+// Returns the quoted name of the table recording repeatable migrations.
+func (m *Migrator) repeatableTable() string {
+	return m.dbAdapter.QuoteTable(m.Schema, m.repeatableTableName())
+}
+
+// Creates the table recording repeatable migrations if it doesn't exist.
+func (m *Migrator) ensureRepeatableTable(ctx context.Context) error {
+	exists, err := m.repeatableTableExists(ctx)
+	if err != nil || exists {
+		return err
+	}
+	if _, err := m.DB.ExecContext(ctx, m.dbAdapter.CreateRepeatableTableSql(m.repeatableTable())); err != nil {
+		m.log().Error("Error creating repeatable migrations table", "table", m.repeatableTable(), "error", err)
+		return fmt.Errorf("table: %s, err: %w: %w", m.repeatableTable(), ErrCreateMigrationTable, err)
+	}
+	m.log().Info("Created repeatable migrations table", "table", m.repeatableTable())
+	return nil
+}
+
+func (m *Migrator) repeatableTableExists(ctx context.Context) (bool, error) {
+	var name string
+	err := m.DB.QueryRowContext(ctx, m.dbAdapter.SelectMigrationTableSql(), m.repeatableTableName(), m.Schema).Scan(&name)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Returns the repeatable migrations whose checksum differs from the one
+// they were last applied with, sorted by name.
+func (m *Migrator) changedRepeatables(ctx context.Context) ([]*Migration, error) {
+	if len(m.repeatables) == 0 {
+		return nil, nil
+	}
+	applied := map[string]string{}
+	exists, err := m.repeatableTableExists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		rows, err := m.DB.QueryContext(ctx, m.dbAdapter.SelectRepeatableChecksumsSql(m.repeatableTable()))
+		if err != nil {
+			m.log().Error("Error reading repeatable migrations", "table", m.repeatableTable(), "error", err)
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var name, checksum string
+			if err := rows.Scan(&name, &checksum); err != nil {
+				return nil, err
+			}
+			applied[name] = checksum
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var changed []*Migration
+	for _, migration := range m.RepeatableMigrations() {
+		checksum, err := m.checksum(migration)
+		if err != nil {
+			return nil, newMigrationError(migration, UpMigration, nil, -1, err)
+		}
+		if previous, ok := applied[migration.Name]; ok && previous == checksum {
+			migration.Status = Active
+			continue
+		}
+		migration.Status = Inactive
+		changed = append(changed, migration)
+	}
+	return changed, nil
+}
+
+// Applies the repeatable migrations that changed since they were last
+// applied, each in a transaction of its own.
+func (m *Migrator) applyRepeatables(ctx context.Context) error {
+	changed, err := m.changedRepeatables(ctx)
+	if err != nil || len(changed) == 0 {
+		return err
+	}
+	if err := m.ensureRepeatableTable(ctx); err != nil {
+		return err
+	}
+	for _, migration := range changed {
+		err := m.withEachHooks(ctx, migration, UpMigration, func() error {
+			return m.applyRepeatable(ctx, migration)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) applyRepeatable(ctx context.Context, migration *Migration) error {
+	logger := m.migrationLogger(migration, UpMigration)
+	logger.Info("Applying repeatable migration")
+	step, err := m.migrationStep(migration, UpMigration)
+	if err == nil && step.noTransaction {
+		err = ErrNoTransactionMigration
+	}
+	if err != nil {
+		return newMigrationError(migration, UpMigration, nil, -1, err)
+	}
+	transaction, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Error("Error opening transaction", "error", err)
+		return newMigrationError(migration, UpMigration, step, -1, err)
+	}
+
+	start := time.Now()
+	if err := m.applyInTransaction(ctx, transaction, migration, UpMigration, step); err != nil {
+		return m.rollback(logger, transaction, err)
+	}
+	if err := transaction.Commit(); err != nil {
+		logger.Error("Error commiting transaction", "error", err)
+		return newMigrationError(migration, UpMigration, step, -1, err)
+	}
+	migration.Status = Active
+	logger.Info("Applied repeatable migration", "duration", time.Since(start))
+	return nil
+}
+
+// Records the checksum a repeatable migration was applied with.
+func (m *Migrator) logRepeatable(ctx context.Context, transaction *sql.Tx, migration *Migration) error {
+	checksum, err := m.checksum(migration)
+	if err != nil {
+		return err
+	}
+	if _, err := transaction.ExecContext(ctx, m.dbAdapter.RepeatableDeleteSql(m.repeatableTable()), migration.Name); err != nil {
+		return err
+	}
+	_, err = transaction.ExecContext(
+		ctx,
+		m.dbAdapter.RepeatableInsertSql(m.repeatableTable()),
+		migration.Name,
+		checksum,
+		time.Now().UTC(),
+		m.AppliedBy,
+	)
+	return err
+}
@@ -0,0 +1,98 @@
+// Adapts Logger implementations to structured logging.
+
+package gomigrate
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// NewLoggerHandler returns a slog.Handler that writes records to a Logger,
+// each as its message followed by its attributes in key=value form, such as
+//
+//	Applied migration migration_id=1 name=add_users direction=up duration=3ms
+//
+// Records of every level are written.
+func NewLoggerHandler(logger Logger) slog.Handler {
+	return &loggerHandler{logger: logger}
+}
+
+type loggerHandler struct {
+	logger Logger
+	// Attributes added by WithAttrs, already formatted.
+	attrs string
+	// Prefix of the keys of attributes in the current group.
+	group string
+}
+
+func (h *loggerHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return true
+}
+
+func (h *loggerHandler) Handle(ctx context.Context, record slog.Record) error {
+	var b strings.Builder
+	b.WriteString(record.Message)
+	b.WriteString(h.attrs)
+	record.Attrs(func(attr slog.Attr) bool {
+		writeAttr(&b, h.group, attr)
+		return true
+	})
+	h.logger.Print(b.String())
+	return nil
+}
+
+func (h *loggerHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+	b.WriteString(h.attrs)
+	for _, attr := range attrs {
+		writeAttr(&b, h.group, attr)
+	}
+	return &loggerHandler{logger: h.logger, attrs: b.String(), group: h.group}
+}
+
+func (h *loggerHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &loggerHandler{logger: h.logger, attrs: h.attrs, group: h.group + name + "."}
+}
+
+// Writes an attribute as " key=value", prefixing its key with group and
+// flattening groups.
+func writeAttr(b *strings.Builder, group string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			group += attr.Key + "."
+		}
+		for _, groupAttr := range attr.Value.Group() {
+			writeAttr(b, group, groupAttr)
+		}
+		return
+	}
+
+	value := fmt.Sprint(attr.Value.Any())
+	if value == "" || strings.ContainsAny(value, " \t\n\r\"=") {
+		value = strconv.Quote(value)
+	}
+	fmt.Fprintf(b, " %s%s=%s", group, attr.Key, value)
+}
+
+// Returns the structured logger the migrator logs to.
+func (m *Migrator) log() *slog.Logger {
+	if m.SlogLogger != nil {
+		return m.SlogLogger
+	}
+	return slog.New(NewLoggerHandler(m.Logger))
+}
+
+// Returns the logger for events about applying a migration.
+func (m *Migrator) migrationLogger(migration *Migration, mType MigrationType) *slog.Logger {
+	return m.log().With("migration_id", migration.ID, "name", migration.Name, "direction", mType)
+}
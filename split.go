@@ -0,0 +1,487 @@
+// Splits migration files into the statements they're made of.
+
+package gomigrate
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// splitOptions describe the lexical rules of a sql dialect.
+type splitOptions struct {
+	// Statement delimiter, or empty to only split on batch separators.
+	delimiter string
+	// Postgres $tag$ quoted strings.
+	dollarQuotes bool
+	// Nested /* */ comments.
+	nestedComments bool
+	// `quoted` identifiers.
+	backticks bool
+	// [quoted] identifiers.
+	brackets bool
+	// # comments.
+	hashComments bool
+	// Backslash escapes in '' and "" strings.
+	backslashEscapes bool
+	// DELIMITER lines changing the delimiter, as in the mysql client.
+	delimiterDirective bool
+	// GO lines separating batches, as in sqlcmd.  GO followed by a count
+	// repeats the batch before it that many times.
+	batchSeparator bool
+	// Postgres BEGIN ATOMIC ... END function bodies, whose statements
+	// don't end the enclosing one.
+	atomicBlocks bool
+	// SQLite BEGIN ... END trigger bodies, whose statements don't end the
+	// enclosing CREATE TRIGGER.
+	triggerBlocks bool
+	// MySQL BEGIN ... END bodies of stored procedures, functions, triggers
+	// and events, whose statements don't end the enclosing CREATE.  They're
+	// only tracked while ; is the delimiter, so bodies written with a
+	// DELIMITER line are left alone.
+	compoundStatements bool
+}
+
+var (
+	dollarQuoteTag = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+	batchSeparator = regexp.MustCompile(`(?i)^GO(?:\s+(\d+))?\s*(?:--.*)?$`)
+)
+
+// Words that start a statement in a SQLite trigger body.
+var triggerStatementWords = map[string]bool{
+	"SELECT":  true,
+	"INSERT":  true,
+	"UPDATE":  true,
+	"DELETE":  true,
+	"REPLACE": true,
+	"WITH":    true,
+}
+
+// Kinds of objects MySQL CREATE statements can give a compound statement
+// body.
+var routineWords = map[string]bool{
+	"PROCEDURE": true,
+	"FUNCTION":  true,
+	"TRIGGER":   true,
+	"EVENT":     true,
+}
+
+// Words that can come before the kind of object in a MySQL CREATE
+// statement.
+var createPrefixWords = map[string]bool{
+	"CREATE":       true,
+	"OR":           true,
+	"REPLACE":      true,
+	"AGGREGATE":    true,
+	"DEFINER":      true,
+	"CURRENT_USER": true,
+}
+
+// Words closed by END in MySQL compound statements, as in END IF.
+var compoundBlockWords = map[string]bool{
+	"IF":     true,
+	"CASE":   true,
+	"LOOP":   true,
+	"WHILE":  true,
+	"REPEAT": true,
+}
+
+// splitStatements splits sql into statements, skipping over quoted strings,
+// identifiers and comments so delimiters inside them are left alone.  The
+// statements are trimmed and don't include their delimiter, and those made
+// only of whitespace and comments are dropped.
+func splitStatements(sql string, options splitOptions) []string {
+	s := &splitter{splitOptions: options, src: sql}
+	return s.split()
+}
+
+type splitter struct {
+	splitOptions
+	src   string
+	start int
+	// Whether the current statement has anything but comments.
+	hasCode bool
+	// How deep in BEGIN ... END and CASE ... END the lexer is.
+	depth int
+	// First word of the current statement, upper cased.
+	firstWord string
+	// Whether the current statement creates a trigger.
+	trigger bool
+	// Whether the kind of object the current CREATE statement makes has
+	// been seen, and whether it has a compound statement body.
+	kindSeen   bool
+	routine    bool
+	statements []string
+}
+
+func (s *splitter) split() []string {
+	i := 0
+	for i < len(s.src) {
+		if i == 0 || s.src[i-1] == '\n' {
+			if next, ok := s.directive(i); ok {
+				i = next
+				continue
+			}
+		}
+
+		c := s.src[i]
+		switch {
+		case s.delimiter != "" && s.depth == 0 && strings.HasPrefix(s.src[i:], s.delimiter):
+			s.flush(i)
+			i += len(s.delimiter)
+			s.start = i
+			continue
+		case c == '-' && strings.HasPrefix(s.src[i:], "--"),
+			c == '#' && s.hashComments:
+			i = s.skipLine(i)
+			continue
+		case c == '/' && strings.HasPrefix(s.src[i:], "/*"):
+			// MySQL runs the contents of /*! */ comments.
+			if strings.HasPrefix(s.src[i:], "/*!") {
+				s.hasCode = true
+			}
+			i = s.skipBlockComment(i)
+			continue
+		}
+
+		if !isSpace(c) {
+			s.hasCode = true
+		}
+		switch {
+		case c == '\'':
+			i = s.skipQuoted(i, '\'', s.backslashEscapes || s.isEscapeString(i))
+		case c == '"':
+			i = s.skipQuoted(i, '"', s.backslashEscapes)
+		case c == '`' && s.backticks:
+			i = s.skipQuoted(i, '`', false)
+		case c == '[' && s.brackets:
+			i = s.skipQuoted(i, ']', false)
+		case c == '$' && s.dollarQuotes && !s.followsWord(i):
+			i = s.skipDollarQuoted(i)
+		case isWordStart(c) && !s.followsWord(i):
+			i = s.word(i)
+		default:
+			i++
+		}
+	}
+	s.flush(len(s.src))
+	return s.statements
+}
+
+// Appends the current statement, which ends at end.
+func (s *splitter) flush(end int) {
+	s.flushRepeated(end, 1)
+}
+
+// Appends the current statement count times.
+func (s *splitter) flushRepeated(end int, count int) {
+	if statement := strings.TrimSpace(s.src[s.start:end]); s.hasCode && statement != "" {
+		for n := 0; n < count; n++ {
+			s.statements = append(s.statements, statement)
+		}
+	}
+	s.hasCode = false
+	s.depth = 0
+	s.firstWord = ""
+	s.trigger = false
+	s.kindSeen = false
+	s.routine = false
+}
+
+// Handles a DELIMITER or GO line starting at i, returning the index after
+// it.
+func (s *splitter) directive(i int) (int, bool) {
+	end := strings.IndexByte(s.src[i:], '\n')
+	next := len(s.src)
+	if end == -1 {
+		end = len(s.src)
+	} else {
+		end += i
+		next = end + 1
+	}
+	line := strings.TrimSpace(s.src[i:end])
+
+	if s.delimiterDirective && !s.hasCode && len(line) > len("delimiter") &&
+		strings.EqualFold(line[:len("delimiter")], "delimiter") && isSpace(line[len("delimiter")]) {
+		s.flush(i)
+		delimiter := strings.TrimSpace(line[len("delimiter"):])
+		if unquoted, err := strconv.Unquote(delimiter); err == nil {
+			delimiter = unquoted
+		}
+		s.delimiter = delimiter
+		s.start = next
+		return next, true
+	}
+	if match := batchSeparator.FindStringSubmatch(line); s.batchSeparator && match != nil {
+		count := 1
+		if match[1] != "" {
+			if n, err := strconv.Atoi(match[1]); err == nil {
+				count = n
+			}
+		}
+		s.flushRepeated(i, count)
+		s.start = next
+		return next, true
+	}
+	return 0, false
+}
+
+// Returns the index of the end of the line i is on.
+func (s *splitter) skipLine(i int) int {
+	if end := strings.IndexByte(s.src[i:], '\n'); end != -1 {
+		return i + end
+	}
+	return len(s.src)
+}
+
+// Returns the index after the comment starting at i.
+func (s *splitter) skipBlockComment(i int) int {
+	depth := 0
+	for j := i; j < len(s.src); {
+		switch {
+		case strings.HasPrefix(s.src[j:], "/*") && (depth == 0 || s.nestedComments):
+			depth++
+			j += 2
+		case strings.HasPrefix(s.src[j:], "*/"):
+			depth--
+			j += 2
+			if depth == 0 {
+				return j
+			}
+		default:
+			j++
+		}
+	}
+	return len(s.src)
+}
+
+// Returns the index after the quoted string or identifier starting at i.
+// Doubling the closing quote escapes it.
+func (s *splitter) skipQuoted(i int, quote byte, backslashEscapes bool) int {
+	for j := i + 1; j < len(s.src); j++ {
+		switch {
+		case backslashEscapes && s.src[j] == '\\':
+			j++
+		case s.src[j] == quote:
+			if j+1 < len(s.src) && s.src[j+1] == quote {
+				j++
+				continue
+			}
+			return j + 1
+		}
+	}
+	return len(s.src)
+}
+
+// Returns the index after the dollar quoted string starting at i.
+func (s *splitter) skipDollarQuoted(i int) int {
+	tag := dollarQuoteTag.FindString(s.src[i:])
+	if tag == "" {
+		return i + 1
+	}
+	end := strings.Index(s.src[i+len(tag):], tag)
+	if end == -1 {
+		return len(s.src)
+	}
+	return i + len(tag) + end + len(tag)
+}
+
+// Returns the index after the word starting at i, keeping track of blocks.
+func (s *splitter) word(i int) int {
+	j := i
+	for j < len(s.src) && isWordChar(s.src[j]) {
+		j++
+	}
+	if !s.atomicBlocks && !s.triggerBlocks && !s.compoundStatements {
+		return j
+	}
+	word := strings.ToUpper(s.src[i:j])
+	if s.firstWord == "" {
+		s.firstWord = word
+	}
+	if s.compoundStatements {
+		return s.compoundWord(i, j, word)
+	}
+	switch word {
+	case "TRIGGER":
+		if s.firstWord == "CREATE" {
+			s.trigger = true
+		}
+	case "BEGIN":
+		// BEGIN is also a transaction statement and a valid identifier,
+		// so only the forms that can open a block do.
+		switch {
+		case s.atomicBlocks && s.nextWord(j) == "ATOMIC",
+			s.triggerBlocks && s.trigger && s.depth == 0 && triggerStatementWords[s.nextWord(j)]:
+			s.depth++
+		}
+	case "CASE":
+		if s.depth > 0 {
+			s.depth++
+		}
+	case "END":
+		if s.depth > 0 {
+			s.depth--
+		}
+	}
+	return j
+}
+
+// Keeps track of the blocks of MySQL compound statements for the word from
+// i to j, returning the index to continue from.
+func (s *splitter) compoundWord(i, j int, word string) int {
+	if s.delimiter != ";" {
+		return j
+	}
+	if s.firstWord == "CREATE" && !s.kindSeen {
+		switch {
+		case routineWords[word]:
+			s.kindSeen = true
+			s.routine = true
+		case createPrefixWords[word], s.previousByte(i) == '=', s.previousByte(i) == '@':
+			// Part of CREATE [OR REPLACE] [DEFINER = user@host], the
+			// user and host being words when unquoted.
+		default:
+			s.kindSeen = true
+		}
+	}
+
+	switch word {
+	case "BEGIN":
+		if s.routine || s.depth > 0 {
+			s.depth++
+		}
+	case "CASE", "LOOP", "WHILE":
+		if s.depth > 0 {
+			s.depth++
+		}
+	case "IF":
+		if s.depth > 0 && s.startsIfStatement(j) {
+			s.depth++
+		}
+	case "REPEAT":
+		if s.depth > 0 && !s.startsArgumentList(j) {
+			s.depth++
+		}
+	case "END":
+		if s.depth > 0 {
+			s.depth--
+		}
+		// END IF, END LOOP and the like close one block, not two.
+		if compoundBlockWords[s.nextWord(j)] {
+			return s.skipNextWord(j)
+		}
+	}
+	return j
+}
+
+// Reports whether the IF ending at i starts an IF statement, rather than
+// calling the IF() function or being part of IF [NOT] EXISTS.
+func (s *splitter) startsIfStatement(i int) bool {
+	if s.startsArgumentList(i) {
+		return false
+	}
+	if s.nextWord(i) == "NOT" {
+		i = s.skipNextWord(i)
+	}
+	if s.nextWord(i) == "EXISTS" {
+		return s.nextByte(s.skipNextWord(i)) == '('
+	}
+	return true
+}
+
+// Reports whether a parenthesised list of several items follows i, as the
+// arguments of IF() and REPEAT() do.
+func (s *splitter) startsArgumentList(i int) bool {
+	for i < len(s.src) && isSpace(s.src[i]) {
+		i++
+	}
+	if i == len(s.src) || s.src[i] != '(' {
+		return false
+	}
+	depth := 0
+	for i < len(s.src) {
+		switch c := s.src[i]; {
+		case c == '\'' || c == '"':
+			i = s.skipQuoted(i, c, s.backslashEscapes)
+			continue
+		case c == '`':
+			i = s.skipQuoted(i, c, false)
+			continue
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return false
+			}
+		case c == ',' && depth == 1:
+			return true
+		}
+		i++
+	}
+	return false
+}
+
+// Returns the upper cased word after i, skipping whitespace, or an empty
+// string if something else follows.
+func (s *splitter) nextWord(i int) string {
+	return strings.ToUpper(strings.TrimLeft(s.src[i:s.skipNextWord(i)], " \t\n\r\f\v"))
+}
+
+// Returns the index after the word following i, skipping whitespace, or
+// after the whitespace if something else follows.
+func (s *splitter) skipNextWord(i int) int {
+	for i < len(s.src) && isSpace(s.src[i]) {
+		i++
+	}
+	for i < len(s.src) && isWordChar(s.src[i]) {
+		i++
+	}
+	return i
+}
+
+// Returns the first byte after i that isn't whitespace, or 0 at the end.
+func (s *splitter) nextByte(i int) byte {
+	for i < len(s.src) && isSpace(s.src[i]) {
+		i++
+	}
+	if i == len(s.src) {
+		return 0
+	}
+	return s.src[i]
+}
+
+// Returns the last byte before i that isn't whitespace, or 0 at the start.
+func (s *splitter) previousByte(i int) byte {
+	for i > 0 && isSpace(s.src[i-1]) {
+		i--
+	}
+	if i == 0 {
+		return 0
+	}
+	return s.src[i-1]
+}
+
+// Reports whether the quote at i starts a Postgres E'...' string.
+func (s *splitter) isEscapeString(i int) bool {
+	return s.dollarQuotes && i > 0 && (s.src[i-1] == 'E' || s.src[i-1] == 'e') && !s.followsWord(i-1)
+}
+
+// Reports whether i is in the middle of an identifier, so $ or a letter
+// there doesn't start anything.
+func (s *splitter) followsWord(i int) bool {
+	return i > 0 && (isWordChar(s.src[i-1]) || s.src[i-1] == '$')
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isWordStart(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isWordChar(c byte) bool {
+	return isWordStart(c) || '0' <= c && c <= '9'
+}
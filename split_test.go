@@ -0,0 +1,194 @@
+package gomigrate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	cases := []struct {
+		name     string
+		adapter  Migratable
+		sql      string
+		expected []string
+	}{
+		{
+			name:     "trailing delimiter and whitespace",
+			adapter:  Postgres{},
+			sql:      "CREATE TABLE a (id INT);\n\nCREATE TABLE b (id INT);\n",
+			expected: []string{"CREATE TABLE a (id INT)", "CREATE TABLE b (id INT)"},
+		},
+		{
+			name:     "delimiters in strings",
+			adapter:  Postgres{},
+			sql:      "INSERT INTO a VALUES ('x;y', 'it''s;');INSERT INTO a VALUES (E'\\';')",
+			expected: []string{"INSERT INTO a VALUES ('x;y', 'it''s;')", "INSERT INTO a VALUES (E'\\';')"},
+		},
+		{
+			name:     "delimiters in quoted identifiers",
+			adapter:  Postgres{},
+			sql:      `CREATE TABLE "a;b" (id INT); DROP TABLE "a;b"`,
+			expected: []string{`CREATE TABLE "a;b" (id INT)`, `DROP TABLE "a;b"`},
+		},
+		{
+			name:    "comments",
+			adapter: Postgres{},
+			sql:     "-- first; statement\nSELECT 1; /* outer /* nested; */ still; */ SELECT 2;\n-- only a comment;\n",
+			expected: []string{
+				"-- first; statement\nSELECT 1",
+				"/* outer /* nested; */ still; */ SELECT 2",
+			},
+		},
+		{
+			name:    "dollar quoting",
+			adapter: Postgres{},
+			sql: "CREATE FUNCTION f() RETURNS void AS $body$ BEGIN PERFORM 1; END $body$ LANGUAGE plpgsql;\n" +
+				"DO $$ BEGIN PERFORM $1; END $$;",
+			expected: []string{
+				"CREATE FUNCTION f() RETURNS void AS $body$ BEGIN PERFORM 1; END $body$ LANGUAGE plpgsql",
+				"DO $$ BEGIN PERFORM $1; END $$",
+			},
+		},
+		{
+			name:     "begin atomic bodies",
+			adapter:  Postgres{},
+			sql:      "CREATE FUNCTION f() RETURNS int LANGUAGE sql BEGIN ATOMIC SELECT 1; SELECT CASE WHEN true THEN 2 END; END; BEGIN; SELECT 3;",
+			expected: []string{"CREATE FUNCTION f() RETURNS int LANGUAGE sql BEGIN ATOMIC SELECT 1; SELECT CASE WHEN true THEN 2 END; END", "BEGIN", "SELECT 3"},
+		},
+		{
+			name:    "begin as an identifier",
+			adapter: Postgres{},
+			sql: "CREATE TABLE periods (begin date, finish date);\nINSERT INTO periods (begin) VALUES ('2020-01-01');\n" +
+				"CREATE INDEX CONCURRENTLY periods_begin ON periods (begin);",
+			expected: []string{
+				"CREATE TABLE periods (begin date, finish date)",
+				"INSERT INTO periods (begin) VALUES ('2020-01-01')",
+				"CREATE INDEX CONCURRENTLY periods_begin ON periods (begin)",
+			},
+		},
+		{
+			name:    "sqlite begin as an identifier",
+			adapter: Sqlite3{},
+			sql:     "CREATE TABLE periods (begin DATE, finish DATE);\nUPDATE periods SET begin = finish;\nBEGIN;\nSELECT 1;",
+			expected: []string{
+				"CREATE TABLE periods (begin DATE, finish DATE)",
+				"UPDATE periods SET begin = finish",
+				"BEGIN",
+				"SELECT 1",
+			},
+		},
+		{
+			name:    "sqlite trigger on a begin column",
+			adapter: Sqlite3{},
+			sql: "CREATE TRIGGER t AFTER UPDATE OF begin ON periods BEGIN UPDATE periods SET begin = NEW.begin; SELECT CASE WHEN 1 THEN 2 END; END;\n" +
+				"SELECT 1;",
+			expected: []string{
+				"CREATE TRIGGER t AFTER UPDATE OF begin ON periods BEGIN UPDATE periods SET begin = NEW.begin; SELECT CASE WHEN 1 THEN 2 END; END",
+				"SELECT 1",
+			},
+		},
+		{
+			name:     "sqlite triggers",
+			adapter:  Sqlite3{},
+			sql:      "CREATE TABLE [a;b] (id INTEGER);\nCREATE TRIGGER t AFTER INSERT ON a BEGIN UPDATE a SET id = 1; DELETE FROM a; END;\n",
+			expected: []string{"CREATE TABLE [a;b] (id INTEGER)", "CREATE TRIGGER t AFTER INSERT ON a BEGIN UPDATE a SET id = 1; DELETE FROM a; END"},
+		},
+		{
+			name:     "mysql quoting and comments",
+			adapter:  Mysql{},
+			sql:      "INSERT INTO `a;b` VALUES ('x\\';y', \"z;\"); # a comment;\nSELECT 1;",
+			expected: []string{"INSERT INTO `a;b` VALUES ('x\\';y', \"z;\")", "# a comment;\nSELECT 1"},
+		},
+		{
+			name:     "mysql leading delimiter",
+			adapter:  Mysql{},
+			sql:      "delimiter #\nCREATE TABLE a (id INT)#CREATE TABLE b (id INT)#",
+			expected: []string{"CREATE TABLE a (id INT)", "CREATE TABLE b (id INT)"},
+		},
+		{
+			name:     "mysql quoted delimiter",
+			adapter:  Mysql{},
+			sql:      "delimiter \"$$\"\nSELECT 1$$SELECT 2",
+			expected: []string{"SELECT 1", "SELECT 2"},
+		},
+		{
+			name:    "mysql delimiter changes mid file",
+			adapter: Mysql{},
+			sql: "DROP PROCEDURE IF EXISTS p;\nDELIMITER //\nCREATE PROCEDURE p()\nBEGIN\n  SELECT 1;\n  SELECT 2;\nEND //\n" +
+				"DELIMITER ;\nCALL p();\n",
+			expected: []string{
+				"DROP PROCEDURE IF EXISTS p",
+				"CREATE PROCEDURE p()\nBEGIN\n  SELECT 1;\n  SELECT 2;\nEND",
+				"CALL p()",
+			},
+		},
+		{
+			name:    "mysql trigger body",
+			adapter: Mysql{},
+			sql:     "CREATE TRIGGER t BEFORE INSERT ON x FOR EACH ROW BEGIN SET NEW.a = 1; SET NEW.b = 2; END;\nINSERT INTO x VALUES (1);",
+			expected: []string{
+				"CREATE TRIGGER t BEFORE INSERT ON x FOR EACH ROW BEGIN SET NEW.a = 1; SET NEW.b = 2; END",
+				"INSERT INTO x VALUES (1)",
+			},
+		},
+		{
+			name:    "mysql procedure body with nested blocks",
+			adapter: Mysql{},
+			sql: "CREATE DEFINER = admin@localhost PROCEDURE p(n INT)\nBEGIN\n" +
+				"  DECLARE i INT DEFAULT 0;\n" +
+				"  DECLARE CONTINUE HANDLER FOR SQLEXCEPTION BEGIN SET i = -1; END;\n" +
+				"  DROP TABLE IF EXISTS tmp;\n" +
+				"  IF NOT EXISTS (SELECT 1 FROM a) THEN SET i = IF(n > 0, n, 0); ELSEIF n = 1 THEN SET i = 1; END IF;\n" +
+				"  l: WHILE i < n DO SET i = i + 1; END WHILE l;\n" +
+				"  REPEAT SELECT REPEAT('x', i); SET i = i - 1; UNTIL i = 0 END REPEAT;\n" +
+				"  SELECT CASE WHEN i = 0 THEN 'done' END;\n" +
+				"END;\n" +
+				"CREATE TABLE events (event INT, begin INT);\nCALL p(2);",
+			expected: []string{
+				"CREATE DEFINER = admin@localhost PROCEDURE p(n INT)\nBEGIN\n" +
+					"  DECLARE i INT DEFAULT 0;\n" +
+					"  DECLARE CONTINUE HANDLER FOR SQLEXCEPTION BEGIN SET i = -1; END;\n" +
+					"  DROP TABLE IF EXISTS tmp;\n" +
+					"  IF NOT EXISTS (SELECT 1 FROM a) THEN SET i = IF(n > 0, n, 0); ELSEIF n = 1 THEN SET i = 1; END IF;\n" +
+					"  l: WHILE i < n DO SET i = i + 1; END WHILE l;\n" +
+					"  REPEAT SELECT REPEAT('x', i); SET i = i - 1; UNTIL i = 0 END REPEAT;\n" +
+					"  SELECT CASE WHEN i = 0 THEN 'done' END;\n" +
+					"END",
+				"CREATE TABLE events (event INT, begin INT)",
+				"CALL p(2)",
+			},
+		},
+	}
+	for _, c := range cases {
+		commands := c.adapter.GetMigrationCommands(c.sql)
+		if !reflect.DeepEqual(commands, c.expected) {
+			t.Errorf("%s: expected %q, got %q", c.name, c.expected, commands)
+		}
+	}
+}
+
+func TestSplitBatches(t *testing.T) {
+	sql := "CREATE TABLE a (id INT);\nSELECT 'GO\nGO';\ngo\n-- GO\n/* GO\n*/CREATE VIEW [v\nGO] AS SELECT id FROM a\n  GO  \n" +
+		"INSERT INTO a DEFAULT VALUES\nGO 3 -- three rows\nGO\nPRINT 'done'"
+	expected := []string{
+		"CREATE TABLE a (id INT);\nSELECT 'GO\nGO';",
+		"-- GO\n/* GO\n*/CREATE VIEW [v\nGO] AS SELECT id FROM a",
+		"INSERT INTO a DEFAULT VALUES",
+		"INSERT INTO a DEFAULT VALUES",
+		"INSERT INTO a DEFAULT VALUES",
+		"PRINT 'done'",
+	}
+	commands := Mssql{}.GetMigrationCommands(sql)
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("Expected %q, got %q", expected, commands)
+	}
+}
+
+func TestStatementLines(t *testing.T) {
+	sql := "SELECT 1;\n\nSELECT\n  2;\nSELECT 1;\nGO 2\n-- note\n/* more\n */ SELECT broken;\n"
+	statements := []string{"SELECT 1", "SELECT\n  2", "SELECT 1", "SELECT 1", "-- note\n/* more\n */ SELECT broken", "missing"}
+	expected := []int{1, 3, 5, 5, 9, 0}
+	if lines := statementLines(sql, statements); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected %v, got %v", expected, lines)
+	}
+}
@@ -0,0 +1,106 @@
+// Reports the state of every migration, loaded or applied.
+
+package gomigrate
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// MigrationState is the state of a migration as reported by Status.
+type MigrationState string
+
+const (
+	// Applied migrations are loaded and recorded in the migration table.
+	Applied = MigrationState("applied")
+	// Pending migrations are loaded, not applied, and newer than every
+	// applied migration.
+	Pending = MigrationState("pending")
+	// Missing migrations are loaded and not applied, but older than the
+	// newest loaded migration that is, so they were skipped.
+	Missing = MigrationState("missing")
+	// Orphaned migrations are recorded in the migration table but not
+	// loaded, such as those from a deleted branch.
+	Orphaned = MigrationState("orphaned")
+)
+
+// MigrationStatus describes one migration in a Status report.
+type MigrationStatus struct {
+	ID    uint64
+	Name  string
+	State MigrationState
+	// AppliedAt is zero for migrations that aren't applied, or that were
+	// applied before the time was recorded.
+	AppliedAt time.Time
+	// Migration is nil for orphaned migrations.
+	Migration *Migration
+}
+
+// Status returns the state of every loaded migration and of every migration
+// recorded in the migration table, sorted by id.  It also refreshes the
+// status of the loaded migrations.  It only reads from the database, so a
+// migration table created by an older version is reported as it is.
+func (m *Migrator) Status() ([]*MigrationStatus, error) {
+	return m.StatusContext(context.Background())
+}
+
+// StatusContext is like Status but honours the cancellation and deadline of
+// the given context.
+func (m *Migrator) StatusContext(ctx context.Context) ([]*MigrationStatus, error) {
+	tableExists, err := m.MigrationTableExistsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*migrationLogEntry
+	if tableExists {
+		if entries, err = m.migrationLog(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var report []*MigrationStatus
+	var latestApplied uint64
+	applied := map[uint64]bool{}
+	for _, entry := range entries {
+		applied[entry.ID] = true
+		status := &MigrationStatus{
+			ID:        entry.ID,
+			Name:      entry.Name,
+			State:     Orphaned,
+			AppliedAt: entry.AppliedAt.Time,
+		}
+		if migration, ok := m.migrations[entry.ID]; ok {
+			migration.Status = Active
+			status.Name = migration.Name
+			status.State = Applied
+			status.Migration = migration
+			if entry.ID > latestApplied {
+				latestApplied = entry.ID
+			}
+		}
+		report = append(report, status)
+	}
+
+	for _, migration := range m.Migrations(-1) {
+		if applied[migration.ID] {
+			continue
+		}
+		migration.Status = Inactive
+		status := &MigrationStatus{
+			ID:        migration.ID,
+			Name:      migration.Name,
+			State:     Pending,
+			Migration: migration,
+		}
+		if migration.ID < latestApplied {
+			status.State = Missing
+		}
+		report = append(report, status)
+	}
+
+	sort.Slice(report, func(a, b int) bool {
+		return report[a].ID < report[b].ID
+	})
+	return report, nil
+}
@@ -0,0 +1,53 @@
+// Renders migration sql as templates.
+
+package gomigrate
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// Renders the sql of a migration with the migrator's template data, or
+// returns it unchanged if there is none.
+func (m *Migrator) renderSQL(migration *Migration, mType MigrationType, sql string) (string, error) {
+	if m.TemplateData == nil || sql == "" {
+		return sql, nil
+	}
+	missingKey := "missingkey=zero"
+	if m.StrictTemplates {
+		missingKey = "missingkey=error"
+	}
+	tmpl, err := template.New(fmt.Sprintf("%d_%s_%s", migration.ID, migration.Name, mType)).
+		Option(missingKey).
+		Funcs(template.FuncMap{"env": m.templateEnv}).
+		Parse(sql)
+	if err != nil {
+		return "", err
+	}
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, m.TemplateData); err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
+
+// Returns the value of an environment variable, for the env template
+// function.  Unset variables are an error with strict templates.
+func (m *Migrator) templateEnv(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok && m.StrictTemplates {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// Returns the checksum of a migration's up sql as rendered.
+func (m *Migrator) checksum(migration *Migration) (string, error) {
+	up, err := m.renderSQL(migration, UpMigration, migration.Up)
+	if err != nil {
+		return "", err
+	}
+	return checksum(up), nil
+}
@@ -1,36 +1,133 @@
 package gomigrate
 
 import (
+	"fmt"
 	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 )
 
+const noTransactionDirective = "gomigrate:no-transaction"
+
 var (
 	upMigrationFile   = regexp.MustCompile(`(\d+)_([\w-]+)_up\.sql`)
 	downMigrationFile = regexp.MustCompile(`(\d+)_([\w-]+)_down\.sql`)
-	subMigrationSplit = regexp.MustCompile(`;\s*`)
-	allWhitespace     = regexp.MustCompile(`^\s*$`)
+	repeatableFile    = regexp.MustCompile(`^R_([\w-]+)\.sql$`)
 )
 
+// Reports whether the comments at the top of a migration include the
+// no-transaction directive.
+func hasNoTransactionHeader(sql string) bool {
+	for _, line := range strings.Split(sql, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			return false
+		}
+		if strings.TrimSpace(strings.TrimPrefix(line, "--")) == noTransactionDirective {
+			return true
+		}
+	}
+	return false
+}
+
+// Quotes a table name, and its schema if there is one, doubling any closing
+// quotes in them.
+func quoteTable(schema, name, open, close string) string {
+	quoted := open + strings.Replace(name, close, close+close, -1) + close
+	if schema == "" {
+		return quoted
+	}
+	return open + strings.Replace(schema, close, close+close, -1) + close + "." + quoted
+}
+
+// Returns the line of sql the code of each statement starts on, after any
+// comments leading it, counting from 1, or 0 for statements that can't be
+// found in it.  Statements are looked for in order, so repeated ones map to
+// successive occurrences.
+func statementLines(sql string, statements []string) []int {
+	lines := make([]int, len(statements))
+	offset, line := 0, 1
+	for i, statement := range statements {
+		index := strings.Index(sql[offset:], statement)
+		if index == -1 {
+			// Batches repeated with GO n are found again from the
+			// previous statement.
+			if i == 0 || statement != statements[i-1] {
+				continue
+			}
+			lines[i] = lines[i-1]
+			continue
+		}
+		code := codeStart(statement)
+		line += strings.Count(sql[offset:offset+index], "\n") + strings.Count(statement[:code], "\n")
+		lines[i] = line
+		line += strings.Count(statement[code:], "\n")
+		offset += index + len(statement)
+	}
+	return lines
+}
+
+// Returns the index in statement after the whitespace and comments it
+// starts with.  MySQL's /*! */ comments hold code, so they aren't skipped.
+func codeStart(statement string) int {
+	i := 0
+	for i < len(statement) {
+		rest := statement[i:]
+		switch {
+		case isSpace(statement[i]):
+			i++
+		case strings.HasPrefix(rest, "--"), strings.HasPrefix(rest, "#"):
+			end := strings.IndexByte(rest, '\n')
+			if end == -1 {
+				return len(statement)
+			}
+			i += end + 1
+		case strings.HasPrefix(rest, "/*") && !strings.HasPrefix(rest, "/*!"):
+			end := strings.Index(rest[2:], "*/")
+			if end == -1 {
+				return len(statement)
+			}
+			i += 2 + end + 2
+		default:
+			return i
+		}
+	}
+	return i
+}
+
 // Returns the migration number, type and base name, so 1, "up", "migration" from "01_migration_up.sql"
-func parseMigrationPath(path string) (uint64, migrationType, string, error) {
+func parseMigrationPath(path string) (uint64, MigrationType, string, error) {
 	filebase := filepath.Base(path)
 
 	matches := upMigrationFile.FindAllSubmatch([]byte(filebase), -1)
 	if matches != nil {
-		return parseMatches(matches, upMigration)
+		return parseMatches(matches, UpMigration)
 	}
 	matches = downMigrationFile.FindAllSubmatch([]byte(filebase), -1)
 	if matches != nil {
-		return parseMatches(matches, downMigration)
+		return parseMatches(matches, DownMigration)
 	}
 
 	return 0, "", "", InvalidMigrationFile
 }
 
+// Returns the name of a repeatable migration file, so "refresh_views" from
+// "R_refresh_views.sql".
+func parseRepeatablePath(path string) (string, bool) {
+	match := repeatableFile.FindStringSubmatch(filepath.Base(path))
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
 // Parses matches given by a migration file regex.
-func parseMatches(matches [][][]byte, mType migrationType) (uint64, migrationType, string, error) {
+func parseMatches(matches [][][]byte, mType MigrationType) (uint64, MigrationType, string, error) {
 	num := matches[0][1]
 	name := matches[0][2]
 	parsedNum, err := strconv.ParseUint(string(num), 10, 64)
@@ -56,3 +153,45 @@ func (u uint64slice) Swap(a, b int) {
 	u[a] = u[b]
 	u[b] = tempA
 }
+
+// Layouts tried when a driver returns a timestamp as text, such as MySQL
+// without parseTime or timestamps stored by go-sqlite3.
+var timeLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	time.RFC3339Nano,
+}
+
+// nullTime scans a nullable timestamp from any of the supported drivers.
+type nullTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+func (t *nullTime) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		t.Time, t.Valid = time.Time{}, false
+		return nil
+	case time.Time:
+		t.Time, t.Valid = v, true
+		return nil
+	case []byte:
+		return t.parse(string(v))
+	case string:
+		return t.parse(v)
+	}
+	return fmt.Errorf("unsupported timestamp type %T", value)
+}
+
+func (t *nullTime) parse(value string) error {
+	for _, layout := range timeLayouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			t.Time, t.Valid = parsed, true
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported timestamp format %q", value)
+}
@@ -0,0 +1,79 @@
+// Detects applied migrations whose source has changed since.
+
+package gomigrate
+
+import (
+	"context"
+	"fmt"
+)
+
+// ChecksumMismatch describes an applied migration whose up migration no
+// longer matches the checksum recorded when it was applied.  With template
+// data, the checksums are of the rendered sql.
+type ChecksumMismatch struct {
+	Migration *Migration
+	Applied   string
+	Current   string
+}
+
+// Verify compares the checksum recorded for every applied migration with its
+// current up migration and returns those that differ.  Migrations applied
+// before checksums were recorded, and applied migrations that aren't loaded,
+// are skipped.  It only reads from the database.
+func (m *Migrator) Verify() ([]*ChecksumMismatch, error) {
+	return m.VerifyContext(context.Background())
+}
+
+// VerifyContext is like Verify but honours the cancellation and deadline of
+// the given context.
+func (m *Migrator) VerifyContext(ctx context.Context) ([]*ChecksumMismatch, error) {
+	tableExists, err := m.MigrationTableExistsContext(ctx)
+	if err != nil || !tableExists {
+		return nil, err
+	}
+	entries, err := m.migrationLog(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var mismatches []*ChecksumMismatch
+	for _, entry := range entries {
+		migration, ok := m.migrations[entry.ID]
+		if !ok || entry.Checksum == "" {
+			continue
+		}
+		current, err := m.checksum(migration)
+		if err != nil {
+			return nil, fmt.Errorf("id: %d, err: %w", migration.ID, err)
+		}
+		if current != entry.Checksum {
+			m.log().Warn("Migration has changed since it was applied", "migration_id", migration.ID, "name", migration.Name, "applied_checksum", entry.Checksum, "checksum", current)
+			mismatches = append(mismatches, &ChecksumMismatch{
+				Migration: migration,
+				Applied:   entry.Checksum,
+				Current:   current,
+			})
+		}
+	}
+	return mismatches, nil
+}
+
+// Returns ErrChecksumMismatch if checksums are being verified and any
+// applied migration has changed.
+func (m *Migrator) refuseChecksumMismatches(ctx context.Context) error {
+	if !m.VerifyChecksums {
+		return nil
+	}
+	mismatches, err := m.VerifyContext(ctx)
+	if err != nil {
+		return err
+	}
+	if len(mismatches) == 0 {
+		return nil
+	}
+	ids := make([]uint64, 0, len(mismatches))
+	for _, mismatch := range mismatches {
+		ids = append(ids, mismatch.Migration.ID)
+	}
+	return fmt.Errorf("ids: %v, err: %w", ids, ErrChecksumMismatch)
+}